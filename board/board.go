@@ -0,0 +1,323 @@
+//Поле и правила игры: края поля, до которых идут игроки, варианты правил, допустимые ходы и препятствия, пути до
+//целей. Правила общие для сервера, который проверяет ходы, и клиентов, которым нужно ходить по правилам. Клетка -
+//это пара {строка, столбец}, препятствие - список пар клеток, между которыми стоят перегородки
+package board
+
+import "math"
+
+//Направления, в которых может ходить игрок: вниз, вверх, вправо, влево
+var directions = [4][2]int8{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+//Край поля, до которого должен дойти игрок. Порядок совпадает с directions
+type Edge uint8
+
+const (
+	EdgeBottom Edge = iota
+	EdgeTop
+	EdgeRight
+	EdgeLeft
+)
+
+//Названия краёв поля, которые отправляются клиентам
+var EdgeNames = [4]string{"bottom", "top", "right", "left"}
+
+//Цели игроков в порядке ходов: первый идёт сверху вниз, второй снизу вверх, третий слева направо, четвёртый
+//справа налево
+var GoalEdges = [4]Edge{EdgeBottom, EdgeTop, EdgeRight, EdgeLeft}
+
+//Проверяет, стоит ли клетка cell на краю e
+func (e Edge) Reached(cell [2]int, width, height int) bool {
+	switch e {
+	case EdgeBottom:
+		return cell[0] == height-1
+	case EdgeTop:
+		return cell[0] == 0
+	case EdgeRight:
+		return cell[1] == width-1
+	default:
+		return cell[1] == 0
+	}
+}
+
+//Край поля по его названию
+func GoalByName(name string) Edge {
+	for i, val := range EdgeNames {
+		if val == name {
+			return Edge(i)
+		}
+	}
+	return EdgeBottom
+}
+
+//Проверяет, что у каждого игрока из positions есть путь до своей цели. Игроки, невидимые в тумане войны,
+//пропускаются
+func AllPathsExist(positions [][2]int, barriers [][][2]int, width, height int, rules Variant) bool {
+	for i, val := range positions {
+		if val == HiddenCell {
+			continue
+		}
+		if !IsPathExists(val, GoalEdges[i], barriers, width, height, rules) {
+			return false
+		}
+	}
+	return true
+}
+
+//Длина кратчайшего пути из position до края goal, поиск в ширину. Если пути нет, то возвращает math.MaxInt32
+func ShortestPath(position [2]int, goal Edge, barriers [][][2]int, width, height int, rules Variant) int {
+	if goal.Reached(position, width, height) {
+		return 0
+	}
+	var distances = make([]int, width*height, width*height)
+	var visitedCells = make([]bool, width*height, width*height)
+	visitedCells[position[0]*width+position[1]] = true
+	var queue = [][2]int{position}
+	for len(queue) > 0 {
+		var current = queue[0]
+		queue = queue[1:]
+		for _, val := range expandMoves(current, barriers, width, height, goal, rules) {
+			if visitedCells[val[0]*width+val[1]] {
+				continue
+			}
+			distances[val[0]*width+val[1]] = distances[current[0]*width+current[1]] + 1
+			if goal.Reached(val, width, height) {
+				return distances[val[0]*width+val[1]]
+			}
+			visitedCells[val[0]*width+val[1]] = true
+			queue = append(queue, val)
+		}
+	}
+	return math.MaxInt32
+}
+
+//Проверяет, существует ли путь из position до края goal
+func IsPathExists(position [2]int, goal Edge, barriers [][][2]int, width, height int, rules Variant) bool {
+	if goal.Reached(position, width, height) {
+		return true
+	}
+	var positions = new(positionStack)
+	positions = nil
+	posPush(&positions, position)
+	var visitedCells = make([]bool, width*height, width*height)
+	visitedCells[position[0]*width+position[1]] = true
+	for {
+		var current, ok = posPop(&positions)
+		if !ok {
+			break
+		}
+		var moves = expandMoves(current, barriers, width, height, goal, rules)
+		for _, val := range moves {
+			if goal.Reached(val, width, height) {
+				return true
+			}
+			if !(val[0] == current[0] && val[1] == current[1]) && !visitedCells[val[0]*width+val[1]] {
+				posPush(&positions, val)
+				visitedCells[val[0]*width+val[1]] = true
+			}
+		}
+	}
+	return false
+}
+
+//Проверяет, совпадают ли препятствия
+func SameBarrier(first, second [][2]int) bool {
+	if len(first) != len(second) {
+		return false
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//Проверяет, можно ли поставить препятствие barrier: оно должно быть в пределах поля, состоять из rules.Segments
+//соседних параллельных перегородок, не пересекаться с barriers и оставлять каждому игроку путь до своей цели
+func IsLegalBarrier(barrier [][2]int, barriers [][][2]int, positions [][2]int, width, height int, rules Variant) bool {
+	if len(barrier) != 2*int(rules.Segments) || !IsValidObstacle(barrier, width, height) {
+		return false
+	}
+	var along = [2]int{int(barrier[1][0]) - int(barrier[0][0]), int(barrier[1][1]) - int(barrier[0][1])}
+	var across = [2]int{int(barrier[2][0]) - int(barrier[0][0]), int(barrier[2][1]) - int(barrier[0][1])}
+	if along[0]*along[0]+along[1]*along[1] != 1 || across[0]*across[0]+across[1]*across[1] != 1 ||
+		along[0]*across[0]+along[1]*across[1] != 0 {
+		return false
+	}
+	for i := 2; i < len(barrier); i++ {
+		if int(barrier[i][0])-int(barrier[i-2][0]) != across[0] || int(barrier[i][1])-int(barrier[i-2][1]) != across[1] {
+			return false
+		}
+	}
+	if CrossesBarriers(barrier, barriers) {
+		return false
+	}
+	return AllPathsExist(positions, append(barriers[:len(barriers):len(barriers)], barrier), width, height, rules)
+}
+
+//Проверяет, перекрывает ли хотя бы одна перегородка препятствия barrier уже стоящие препятствия
+func CrossesBarriers(barrier [][2]int, barriers [][][2]int) bool {
+	for i := 0; i+1 < len(barrier); i += 2 {
+		if isStepOver(barrier[i], barrier[i+1], barriers) {
+			return true
+		}
+	}
+	return false
+}
+
+//Возвращает клетки, в которые игрок может переместиться из position по правилам rules. Вставать на клетки
+//occupied, занятые другими игроками, нельзя. Если разрешены прыжки, то через стоящего рядом игрока можно
+//перепрыгнуть, а если прыжку мешает препятствие, край поля или ещё один игрок, то обойти его по диагонали
+func StepMoves(position [2]int, occupied [][2]int, barriers [][][2]int, width, height int, rules Variant) [][2]int {
+	var res = make([][2]int, 0, 8)
+	var isOccupied = func(cell [2]int) bool {
+		for _, val := range occupied {
+			if val == cell {
+				return true
+			}
+		}
+		return false
+	}
+	var isFree = func(from [2]int, dir [2]int8) bool {
+		return rules.passable(from, dir, barriers, width, height) && !isOccupied(rules.shift(from, dir, width))
+	}
+	for _, dir := range rules.moveDirections() {
+		if !rules.passable(position, dir, barriers, width, height) {
+			continue
+		}
+		var to = rules.shift(position, dir, width)
+		if !isOccupied(to) {
+			res = append(res, to)
+			continue
+		}
+		if !rules.Jumps || dir[0] != 0 && dir[1] != 0 {
+			continue
+		}
+		if isFree(to, dir) {
+			res = append(res, rules.shift(to, dir, width))
+			continue
+		}
+		for _, side := range [2][2]int8{{dir[1], dir[0]}, {-dir[1], -dir[0]}} {
+			if isFree(to, side) {
+				res = append(res, rules.shift(to, side, width))
+			}
+		}
+	}
+	return res
+}
+
+//Сдвигает клетку на одну в направлении dir. Клетка может оказаться за пределами поля, это проверяет inField
+func shiftCell(cell [2]int, dir [2]int8) [2]int {
+	return [2]int{cell[0] + int(dir[0]), cell[1] + int(dir[1])}
+}
+
+//Проверяет, что клетка лежит в пределах поля
+func inField(cell [2]int, width, height int) bool {
+	return cell[0] >= 0 && cell[0] < height && cell[1] >= 0 && cell[1] < width
+}
+
+//Получает список доступных ходов по правилам rules. Первым идёт ход в сторону края goal, за ним ходы вбок и
+//назад, а потом, если можно, ходы по диагонали
+func expandMoves(pos [2]int, barriers [][][2]int, width, height int, goal Edge, rules Variant) [][2]int {
+	var res = make([][2]int, 0, 8)
+	var forward = directions[goal]
+	var moves = [][2]int8{
+		forward,
+		{forward[1], forward[0]},
+		{-forward[1], -forward[0]},
+		{-forward[0], -forward[1]},
+	}
+	if rules.Diagonal {
+		moves = append(moves, diagonals[:]...)
+	}
+	for _, dir := range moves {
+		if rules.passable(pos, dir, barriers, width, height) {
+			res = append(res, rules.shift(pos, dir, width))
+		}
+	}
+	return res
+}
+
+//Проверяет, пересекает ли ход из from в to одно из препятствий. Препятствие - это список пар клеток, между
+//которыми стоят перегородки
+func isStepOver(from, to [2]int, barriers [][][2]int) bool {
+	for _, barrier := range barriers {
+		for i := 0; i+1 < len(barrier); i += 2 {
+			if from == barrier[i] && to == barrier[i+1] || to == barrier[i] && from == barrier[i+1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Проверяет, ставится ли препятствие в пределах поля
+func IsValidObstacle(barrier [][2]int, width, height int) bool {
+	if len(barrier) == 0 || len(barrier)%2 != 0 {
+		return false
+	}
+	for _, cell := range barrier {
+		if !inField(cell, width, height) {
+			return false
+		}
+	}
+	return true
+}
+
+//Генерирует случаное препятствие из segments перегородок в точке (x,y), где x - строка, а y - столбец, dir in
+//[0,7] - одно из восьми возможных направлений
+func RandomBarrier(x, y, dir int, segments uint8) [][2]int {
+	var first [4][2]int
+	switch dir {
+	case 0:
+		first = [4][2]int{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
+	case 1:
+		first = [4][2]int{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}}
+	case 2:
+		first = [4][2]int{{x, y}, {x - 1, y}, {x, y - 1}, {x - 1, y - 1}}
+	case 3:
+		first = [4][2]int{{x, y}, {x - 1, y}, {x, y + 1}, {x - 1, y + 1}}
+	case 4:
+		first = [4][2]int{{x, y}, {x, y + 1}, {x + 1, y}, {x + 1, y + 1}}
+	case 5:
+		first = [4][2]int{{x, y}, {x, y - 1}, {x + 1, y}, {x + 1, y - 1}}
+	case 6:
+		first = [4][2]int{{x, y}, {x, y + 1}, {x - 1, y}, {x - 1, y + 1}}
+	case 7:
+		first = [4][2]int{{x, y}, {x, y - 1}, {x - 1, y}, {x - 1, y - 1}}
+	default:
+		first = [4][2]int{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
+	}
+	var res = append(make([][2]int, 0, 2*segments), first[:]...)
+	for i := 2; i < int(segments); i++ {
+		var last = len(res)
+		res = append(res,
+			[2]int{2*res[last-2][0] - res[last-4][0], 2*res[last-2][1] - res[last-4][1]},
+			[2]int{2*res[last-1][0] - res[last-3][0], 2*res[last-1][1] - res[last-3][1]})
+	}
+	return res
+}
+
+//Стек клеток для поиска пути
+type positionStack struct {
+	f    [2]int
+	next *positionStack
+}
+
+func posPush(stack **positionStack, position [2]int) {
+	var newRoot = new(positionStack)
+	*newRoot = positionStack{
+		f:    position,
+		next: *stack,
+	}
+	*stack = newRoot
+}
+
+func posPop(stack **positionStack) ([2]int, bool) {
+	if *stack == nil {
+		return [2]int{}, false
+	}
+	var temp = *stack
+	*stack = (*stack).next
+	return temp.f, true
+}
@@ -0,0 +1,52 @@
+package board
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStepMoves(t *testing.T) {
+	var classic, jumps = Rules(ClassicVariant, false), Rules(JumpsVariant, false)
+	var diagonal, torus = Rules(DiagonalVariant, false), Rules(TorusVariant, false)
+	//Перегородки под клеткой {2,2} и справа от неё
+	var below = [][2]int{{2, 2}, {3, 2}, {2, 3}, {3, 3}}
+	var right = [][2]int{{2, 2}, {2, 3}, {1, 2}, {1, 3}}
+	var tests = []struct {
+		name     string
+		position [2]int
+		occupied [][2]int
+		barriers [][][2]int
+		rules    Variant
+		want     [][2]int
+	}{
+		{"all directions", [2]int{2, 2}, nil, nil, classic, [][2]int{{3, 2}, {1, 2}, {2, 3}, {2, 1}}},
+		{"corner", [2]int{0, 0}, nil, nil, classic, [][2]int{{1, 0}, {0, 1}}},
+		{"barrier", [2]int{2, 2}, nil, [][][2]int{below}, classic, [][2]int{{1, 2}, {2, 3}, {2, 1}}},
+		{"occupied", [2]int{2, 2}, [][2]int{{3, 2}}, nil, classic, [][2]int{{1, 2}, {2, 3}, {2, 1}}},
+		{"jump", [2]int{2, 2}, [][2]int{{3, 2}}, nil, jumps, [][2]int{{4, 2}, {1, 2}, {2, 3}, {2, 1}}},
+		{"jump flag in classic rules", [2]int{2, 2}, [][2]int{{3, 2}}, nil, Rules(ClassicVariant, true),
+			[][2]int{{4, 2}, {1, 2}, {2, 3}, {2, 1}}},
+		{"jump over the edge goes sideways", [2]int{3, 2}, [][2]int{{4, 2}}, nil, jumps,
+			[][2]int{{4, 3}, {4, 1}, {2, 2}, {3, 3}, {3, 1}}},
+		{"jump over a barrier goes sideways", [2]int{2, 2}, [][2]int{{3, 2}}, [][][2]int{{{3, 2}, {4, 2}, {3, 3}, {4, 3}}}, jumps,
+			[][2]int{{3, 3}, {3, 1}, {1, 2}, {2, 3}, {2, 1}}},
+		{"jump over two players goes sideways", [2]int{2, 2}, [][2]int{{3, 2}, {4, 2}}, nil, jumps,
+			[][2]int{{3, 3}, {3, 1}, {1, 2}, {2, 3}, {2, 1}}},
+		{"diagonals", [2]int{2, 2}, nil, nil, diagonal,
+			[][2]int{{3, 2}, {1, 2}, {2, 3}, {2, 1}, {3, 3}, {3, 1}, {1, 3}, {1, 1}}},
+		{"diagonal around a barrier", [2]int{2, 2}, nil, [][][2]int{below, right}, diagonal,
+			[][2]int{{1, 2}, {2, 1}, {3, 1}, {1, 1}}},
+		{"no diagonal jumps", [2]int{2, 2}, [][2]int{{3, 3}}, nil, Rules(DiagonalVariant, true),
+			[][2]int{{3, 2}, {1, 2}, {2, 3}, {2, 1}, {3, 1}, {1, 3}, {1, 1}}},
+		{"torus wraps around", [2]int{2, 0}, nil, nil, torus, [][2]int{{3, 0}, {1, 0}, {2, 1}, {2, 4}}},
+		{"no wrap without torus", [2]int{2, 0}, nil, nil, classic, [][2]int{{3, 0}, {1, 0}, {2, 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var moves = StepMoves(test.position, test.occupied, test.barriers, 5, 5, test.rules)
+			if !reflect.DeepEqual(moves, test.want) {
+				t.Errorf("StepMoves(%v) = %v, want %v", test.position, moves, test.want)
+			}
+		})
+	}
+}
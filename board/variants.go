@@ -0,0 +1,91 @@
+package board
+
+//Идентификаторы вариантов правил. Они сохраняются в лобби и не должны меняться
+const (
+	ClassicVariant      = "classic"       //Обычные правила: ходы на соседнюю клетку, препятствия из двух перегородок
+	JumpsVariant        = "jumps"         //Через стоящего рядом игрока можно перепрыгнуть
+	LongBarriersVariant = "long_barriers" //Препятствия из трёх перегородок
+	TorusVariant        = "torus"         //Левый и правый края поля склеены
+	DiagonalVariant     = "diagonal"      //Можно ходить по диагонали
+	FogVariant          = "fog"           //Туман войны: игрок видит только то, что рядом с ним
+)
+
+//Позиция невидимого игрока в тумане войны
+var HiddenCell = [2]int{-1, -1}
+
+//Вариант правил игры
+type Variant struct {
+	Jumps    bool  //Можно ли перепрыгивать через других игроков
+	Segments uint8 //Из скольких перегородок состоит препятствие
+	Torus    bool  //Склеены ли левый и правый края поля
+	Diagonal bool  //Можно ли ходить по диагонали
+	Fog      bool  //Видят ли игроки только то, что рядом с ними
+}
+
+//Реестр вариантов правил
+var Variants = map[string]Variant{
+	ClassicVariant:      {Segments: 2},
+	JumpsVariant:        {Segments: 2, Jumps: true},
+	LongBarriersVariant: {Segments: 3},
+	TorusVariant:        {Segments: 2, Torus: true},
+	DiagonalVariant:     {Segments: 2, Diagonal: true},
+	FogVariant:          {Segments: 2, Fog: true},
+}
+
+//Диагональные направления
+var diagonals = [4][2]int8{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+
+//Правила варианта name. Если вариант не указан или неизвестен, то используются обычные правила, jumps разрешает
+//прыжки в любом варианте
+func Rules(name string, jumps bool) Variant {
+	var res, ok = Variants[name]
+	if !ok {
+		res = Variants[ClassicVariant]
+	}
+	if jumps {
+		res.Jumps = true
+	}
+	return res
+}
+
+//Сдвигает клетку на одну в направлении dir. На торе выход за левый или правый край переносит на другой край
+func (v Variant) shift(cell [2]int, dir [2]int8, width int) [2]int {
+	var res = shiftCell(cell, dir)
+	if v.Torus {
+		if res[1] < 0 {
+			res[1] = width - 1
+		} else if res[1] == width {
+			res[1] = 0
+		}
+	}
+	return res
+}
+
+//Проверяет, можно ли шагнуть из from в направлении dir, не выходя за поле и не пересекая препятствия. По
+//диагонали можно пройти, если свободен хотя бы один из двух обходов через соседние клетки
+func (v Variant) passable(from [2]int, dir [2]int8, barriers [][][2]int, width, height int) bool {
+	var to = v.shift(from, dir, width)
+	if !inField(to, width, height) {
+		return false
+	}
+	if dir[0] == 0 || dir[1] == 0 {
+		return !isStepOver(from, to, barriers)
+	}
+	for _, first := range [2][2]int8{{dir[0], 0}, {0, dir[1]}} {
+		var via = v.shift(from, first, width)
+		if inField(via, width, height) && !isStepOver(from, via, barriers) && !isStepOver(via, to, barriers) {
+			return true
+		}
+	}
+	return false
+}
+
+//Направления, в которых можно ходить по этим правилам
+func (v Variant) moveDirections() [][2]int8 {
+	var res = make([][2]int8, 0, 8)
+	res = append(res, directions[:]...)
+	if v.Diagonal {
+		res = append(res, diagonals[:]...)
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+//Клиентская сторона протокола сервера для проверок и нагрузочных ботов: данные, которые клиенты получают
+//и отправляют, и стратегии ходов. Пакет не зависит от сервера и его хранилища, правила ходов берутся из board
+package client
+
+//Коды ошибок сервера, на которые клиенты отвечают по-особому. Полный список есть в спецификации протокола
+const (
+	ErrHiddenConflict     = "HIDDEN_CONFLICT"      //Ход противоречит невидимому в тумане войны, нужно сходить ещё раз
+	ErrTooManyConnections = "TOO_MANY_CONNECTIONS" //Сервер не принимает больше подключений
+	ErrThrottled          = "THROTTLED"            //Превышено ограничение частоты запросов, retryAfter - через сколько мс повторить
+	ErrNoScheduledGame    = "NO_SCHEDULED_GAME"    //Игры по расписанию закончились
+)
+
+//Лобби, как его присылает сервер. Здесь только то, что нужно клиентам, чтобы играть
+type LobbyInfo struct {
+	ID           *string `json:"_id"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+	Name         string  `json:"name"`
+	PlayersCount int     `json:"players_count"`
+	Jumps        bool    `json:"jumps"`
+	Variant      string  `json:"variant"`
+	Visibility   int     `json:"visibility"`
+}
+
+//Ответ на SOCKET JOINLOBBY
+type JoinLobbyResponse struct {
+	Data    LobbyInfo `json:"DATA"`
+	Success bool      `json:"SUCCESS"`
+}
+
+//Поле с точки зрения игрока: его позиция, цель и время идут первыми. Ход - это поле после него
+type Field struct {
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
+	Goals            []string   `json:"goals"`
+	Visible          []bool     `json:"visible,omitempty"`
+	BarriersLeft     uint8      `json:"barriersLeft"`
+	TimeLeft         uint32     `json:"timeLeft"`
+	OpponentTimeLeft uint32     `json:"opponentTimeLeft"`
+	TimesLeft        []uint32   `json:"timesLeft"`
+}
+
+//Данные SOCKET STARTGAME: начальное поле и то, ходит ли игрок первым
+type StartGame struct {
+	Move bool `json:"move"`
+	Field
+}
+
+//Данные SOCKET ENDGAME
+type EndGame struct {
+	Result string `json:"result"`
+	Reason string `json:"reason"`
+	Place  uint8  `json:"place"`
+	Field
+	MoveTimes []uint32 `json:"moveTimes"`
+}
+
+//Ответ сервера с ошибкой
+type ErrorResponse struct {
+	Error ErrorInfo `json:"ERROR"`
+}
+
+type ErrorInfo struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter uint32 `json:"retryAfter,omitempty"`
+}
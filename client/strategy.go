@@ -0,0 +1,70 @@
+package client
+
+import (
+	"goServer/board"
+	"math/rand"
+)
+
+//Выбирает ход игрока по его виду поля view. Возвращает поле после хода в том же виде
+type Strategy func(view Field) Field
+
+//Ходит на соседнюю клетку, с которой кратчайший путь до цели короче всего, по обычным правилам. Препятствия не
+//ставит
+func ShortestPathStrategy(view Field) Field {
+	var rules = board.Rules(board.ClassicVariant, false)
+	var goal = board.GoalByName(view.Goals[0])
+	var best, bestLength = view.Position, -1
+	for _, val := range board.StepMoves(view.Position, view.Positions[1:], view.Barriers, view.Width, view.Height, rules) {
+		var length = board.ShortestPath(val, goal, view.Barriers, view.Width, view.Height, rules)
+		if length >= 0 && (bestLength < 0 || length < bestLength) {
+			best, bestLength = val, length
+		}
+	}
+	var res = view
+	res.Positions = append([][2]int{best}, view.Positions[1:]...)
+	res.Position = best
+	return res
+}
+
+//Делает случайный допустимый ход по правилам лобби info: с вероятностью barrierChance ставит случайное
+//препятствие, если они ещё остались, иначе ходит на случайную соседнюю клетку. Если ходить некуда, то остаётся на
+//месте, и сервер засчитывает пропуск хода как недопустимый ход. rnd не должен использоваться из нескольких
+//горутин. В тумане войны ход может упереться в то, чего игрок не видит, тогда сервер отвечает HIDDEN_CONFLICT и
+//нужно сходить ещё раз
+func RandomStrategy(rnd *rand.Rand, info LobbyInfo, barrierChance float64) Strategy {
+	var rules = board.Rules(info.Variant, info.Jumps)
+	return func(view Field) Field {
+		var res = view
+		if view.BarriersLeft > 0 && rnd.Float64() < barrierChance {
+			for attempt := 0; attempt < 20; attempt++ {
+				var barrier = board.RandomBarrier(rnd.Intn(view.Height), rnd.Intn(view.Width), rnd.Intn(8), rules.Segments)
+				if board.IsLegalBarrier(barrier, view.Barriers, nil, view.Width, view.Height, rules) &&
+					viewPathsExist(view, append(view.Barriers[:len(view.Barriers):len(view.Barriers)], barrier), rules) {
+					res.Barriers = append(view.Barriers[:len(view.Barriers):len(view.Barriers)], barrier)
+					return res
+				}
+			}
+		}
+		var moves = board.StepMoves(view.Position, view.Positions[1:], view.Barriers, view.Width, view.Height, rules)
+		if len(moves) == 0 {
+			return res
+		}
+		var move = moves[rnd.Intn(len(moves))]
+		res.Positions = append([][2]int{move}, view.Positions[1:]...)
+		res.Position = move
+		return res
+	}
+}
+
+//Проверяет, что при препятствиях barriers у каждого видимого игрока поля view есть путь до его цели
+func viewPathsExist(view Field, barriers [][][2]int, rules board.Variant) bool {
+	for i, val := range view.Positions {
+		if val == board.HiddenCell || i >= len(view.Goals) {
+			continue
+		}
+		if !board.IsPathExists(val, board.GoalByName(view.Goals[i]), barriers, view.Width, view.Height, rules) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,371 @@
+//Проверка сервера на соответствие спецификации протокола resources/protocol.schema.json. Подключается к
+//запущенному серверу по TCP, проходит вход, лобби, игру и ошибки и проверяет каждый ответ и событие по
+//спецификации. Нужны два логина из списка участников. Игра записывается в результаты, поэтому запускать
+//лучше на тестовом сервере:
+//
+//	go run ./conformance -addr localhost:5703 -login1 alice -login2 bob
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goServer/schema"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	addr    = flag.String("addr", "localhost:5703", "server address")
+	spec    = flag.String("spec", "resources/protocol.schema.json", "protocol specification")
+	login1  = flag.String("login1", "", "first participant login")
+	login2  = flag.String("login2", "", "second participant login")
+	timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for each reply")
+)
+
+//Спецификация, по которой проверяются ответы
+var protocol *schema.Spec
+
+//Сколько раз повторять команду, на которую сервер ответил THROTTLED
+const throttleRetries = 3
+
+func main() {
+	flag.Parse()
+	if *login1 == "" || *login2 == "" {
+		fmt.Fprintln(os.Stderr, "both -login1 and -login2 are required")
+		os.Exit(2)
+	}
+	var err error
+	if protocol, err = schema.Load(*spec); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	var failed = 0
+	for _, val := range checks {
+		if err := val.run(); err != nil {
+			failed += 1
+			fmt.Printf("FAIL %s: %s\n", val.name, err.Error())
+		} else {
+			fmt.Printf("PASS %s\n", val.name)
+		}
+	}
+	fmt.Printf("%d of %d checks passed\n", len(checks)-failed, len(checks))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+//Проверка
+type check struct {
+	name string
+	run  func() error
+}
+
+var checks = []check{
+	{"errors before login", checkErrorsBeforeLogin},
+	{"session", checkSession},
+	{"game", checkGame},
+}
+
+//Клиент, говорящий с сервером строками
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial() (*client, error) {
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		return nil, err
+	}
+	return &client{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *client) close() {
+	_ = c.conn.Close()
+}
+
+//Отправляет команду command с данными payload, которые могут быть пустыми
+func (c *client) send(command, payload string) error {
+	var line = command
+	if payload != "" {
+		line += " " + payload
+	}
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+//Читает одну строку от сервера
+func (c *client) read() (string, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(*timeout))
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+//Отправляет команду и читает ответ. Если сервер ответил THROTTLED, то ждёт и повторяет команду
+func (c *client) command(command, payload string) (string, error) {
+	for i := 0; ; i++ {
+		if err := c.send(command, payload); err != nil {
+			return "", err
+		}
+		reply, err := c.read()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", command, err)
+		}
+		var response struct {
+			Error struct {
+				Code       string `json:"code"`
+				RetryAfter uint32 `json:"retryAfter"`
+			} `json:"ERROR"`
+		}
+		_ = json.Unmarshal([]byte(reply), &response)
+		if response.Error.Code != "THROTTLED" || i == throttleRetries {
+			return reply, nil
+		}
+		time.Sleep(time.Duration(response.Error.RetryAfter+50) * time.Millisecond)
+	}
+}
+
+//Отправляет команду и проверяет, что ответ подходит под определение def из спецификации. reqID - REQ_ID
+//команды в JSON или пустая строка
+func (c *client) expect(command, payload, def, reqID string) (string, error) {
+	reply, err := c.command(command, payload)
+	if err != nil {
+		return "", err
+	}
+	return reply, checkReply(command, reply, def, reqID)
+}
+
+//Проверяет ответ на команду command. Ответ с REQ_ID, который не является объектом, приходит обёрнутым в
+//TaggedResponse
+func checkReply(command, reply, def, reqID string) error {
+	var data = []byte(reply)
+	if reqID != "" {
+		var tagged struct {
+			ReqID json.RawMessage `json:"REQ_ID"`
+			Data  json.RawMessage `json:"DATA"`
+		}
+		if err := json.Unmarshal(data, &tagged); err != nil {
+			return fmt.Errorf("%s: %w in %s", command, err, reply)
+		}
+		if string(tagged.ReqID) != reqID {
+			return fmt.Errorf("%s: REQ_ID %s expected, got %s", command, reqID, string(tagged.ReqID))
+		}
+		if protocol.Validate("TaggedResponse", data) == nil && tagged.Data != nil && !strings.HasPrefix(string(tagged.Data), "{") {
+			data = tagged.Data
+		}
+	}
+	if err := protocol.Validate(def, data); err != nil {
+		return fmt.Errorf("%s: reply %s is not %s: %w", command, reply, def, err)
+	}
+	return nil
+}
+
+//Отправляет команду и проверяет, что сервер ответил ошибкой с кодом code
+func (c *client) expectError(command, payload, code string) error {
+	reply, err := c.expect(command, payload, "ErrorResponse", "")
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"ERROR"`
+	}
+	_ = json.Unmarshal([]byte(reply), &response)
+	if response.Error.Code != code {
+		return fmt.Errorf("%s: error %s expected, got %s", command, code, reply)
+	}
+	return nil
+}
+
+//Отправляет команду и проверяет, что сервер ответил сообщением message
+func (c *client) expectMessage(command, payload, message, reqID string) error {
+	reply, err := c.expect(command, payload, "Message", reqID)
+	if err != nil {
+		return err
+	}
+	var msg struct {
+		Msg string `json:"MESSAGE"`
+	}
+	_ = json.Unmarshal([]byte(reply), &msg)
+	if msg.Msg != message {
+		return fmt.Errorf("%s: message %s expected, got %s", command, message, reply)
+	}
+	return nil
+}
+
+//Ждёт событие event и проверяет его данные по спецификации. Возвращает данные события
+func (c *client) expectEvent(event string) (string, error) {
+	line, err := c.read()
+	if err != nil {
+		return "", fmt.Errorf("waiting for %s: %w", event, err)
+	}
+	if !strings.HasPrefix(line, event+" ") {
+		return "", fmt.Errorf("%s expected, got %s", event, line)
+	}
+	var payload = strings.TrimPrefix(line, event+" ")
+	if err = protocol.Validate(protocol.Events[event].Payload, []byte(payload)); err != nil {
+		return "", fmt.Errorf("%s: %w", event, err)
+	}
+	return payload, nil
+}
+
+//Входит под логином login
+func login(login string) (*client, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.expectMessage("CONNECTION", fmt.Sprintf(`{"LOGIN":%q}`, login), "LOGIN OK", ""); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+//Ошибки, которые можно получить до входа
+func checkErrorsBeforeLogin() error {
+	c, err := dial()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return errors.Join(
+		c.expectError("HELLO", "", "UNKNOWN_COMMAND"),
+		c.expectError("SOCKET JOINLOBBY", `{"id":null}`, "LOGIN_REQUIRED"),
+		c.expectError("CONNECTION", "", "BAD_REQUEST"),
+		c.expectError("CONNECTION", `{"LOGIN":5}`, "BAD_REQUEST"),
+		c.expectError("CONNECTION", `{"LOGIN":"conformance_nobody"}`, "LOGIN_FAILED"),
+		c.expectError("SOCKET STEP", `{"width":3}`, "NOT_PLAYING"),
+	)
+}
+
+//Вход, запросы, создание лобби, вход в лобби и выход из него, REQ_ID и выход с сервера
+func checkSession() error {
+	c, err := dial()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if err = c.expectMessage("CONNECTION", fmt.Sprintf(`{"LOGIN":%q,"REQ_ID":"login-1"}`, *login1), "LOGIN OK", `"login-1"`); err != nil {
+		return err
+	}
+	var errs []error
+	var record = func(_ string, err error) {
+		errs = append(errs, err)
+	}
+	record(c.expect("GET LOBBY", "", "GetLobbyResponse", ""))
+	record(c.expect("GET RANDOMLOBBY", `{"REQ_ID":1}`, "LobbyID", "1"))
+	record(c.expect("GET STATS", `{"REQ_ID":2}`, "StatsList", "2"))
+	record(c.expect("GET PAIRS", "", "PairResultList", ""))
+	record(c.expect("GET PLAYERSTATS", fmt.Sprintf(`{"LOGIN":%q}`, *login1), "PlayerStatsList", ""))
+	errs = append(errs,
+		c.expectError("POST LOBBY", `{"width":1,"height":1}`, "INVALID_LOBBY"),
+		c.expectError("POST LOBBY", `{"width":"wide"}`, "BAD_REQUEST"),
+		c.expectError("GET FIELD", `{"width":1,"height":1}`, "INVALID_FIELD"),
+		c.expectError("SOCKET LEAVELOBBY", "", "NOT_IN_LOBBY"),
+		c.expectError("SOCKET JOINLOBBY", `{"id":"999999999"}`, "LOBBY_NOT_FOUND"),
+		c.expectError("GET STATS", `{"REQ_ID":{"a":1}}`, "BAD_REQUEST"),
+	)
+	id, err := postLobby(c)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	record(c.expect("GET FIELD", fmt.Sprintf(`{"_id":%q}`, id), "Field", ""))
+	record(c.expect("SOCKET JOINLOBBY", fmt.Sprintf(`{"id":%q}`, id), "JoinLobbyResponse", ""))
+	errs = append(errs,
+		c.expectMessage("SOCKET LEAVELOBBY", "", "OK", ""),
+		c.expectMessage("DISCONNECT", `{"REQ_ID":9}`, "BYE", "9"),
+	)
+	return errors.Join(errs...)
+}
+
+//Создаёт лобби для игры двух участников на поле 5x5 и возвращает его id
+func postLobby(c *client) (string, error) {
+	var info = fmt.Sprintf(`{"width":5,"height":5,"gameBarrierCount":2,"playerBarrierCount":2,"players_count":2,"name":"conformance_%d"}`,
+		time.Now().UnixNano())
+	reply, err := c.expect("POST LOBBY", info, "LobbyID", "")
+	if err != nil {
+		return "", err
+	}
+	var lobby struct {
+		ID *string `json:"id"`
+	}
+	_ = json.Unmarshal([]byte(reply), &lobby)
+	if lobby.ID == nil {
+		return "", errors.New("POST LOBBY: no lobby id in " + reply)
+	}
+	return *lobby.ID, nil
+}
+
+//Игра двух участников: оба входят в лобби, получают SOCKET STARTGAME, первый ходящий присылает ход в неверном
+//формате и проигрывает, оба получают SOCKET ENDGAME
+func checkGame() error {
+	first, err := login(*login1)
+	if err != nil {
+		return err
+	}
+	defer first.close()
+	second, err := login(*login2)
+	if err != nil {
+		return err
+	}
+	defer second.close()
+	id, err := postLobby(first)
+	if err != nil {
+		return err
+	}
+	var players = []*client{first, second}
+	for _, val := range players {
+		if _, err = val.expect("SOCKET JOINLOBBY", fmt.Sprintf(`{"id":%q}`, id), "JoinLobbyResponse", ""); err != nil {
+			return err
+		}
+	}
+	var mover = -1
+	for i, val := range players {
+		payload, err := val.expectEvent("SOCKET STARTGAME")
+		if err != nil {
+			return err
+		}
+		var start struct {
+			Move bool `json:"move"`
+		}
+		_ = json.Unmarshal([]byte(payload), &start)
+		if start.Move {
+			mover = i
+		}
+	}
+	if mover < 0 {
+		return errors.New("SOCKET STARTGAME: nobody has the first move")
+	}
+	if err = players[mover].send("SOCKET STEP", `{"width":"wrong"}`); err != nil {
+		return err
+	}
+	for i, val := range players {
+		payload, err := val.expectEvent("SOCKET ENDGAME")
+		if err != nil {
+			return err
+		}
+		var end struct {
+			Result string `json:"result"`
+			Reason string `json:"reason"`
+		}
+		_ = json.Unmarshal([]byte(payload), &end)
+		var result = "win"
+		if i == mover {
+			result = "lose"
+		}
+		if end.Result != result || end.Reason != "format" {
+			return fmt.Errorf("SOCKET ENDGAME: %s by format expected, got %s", result, payload)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+//Нагрузочная проверка сервера: запускает в одном процессе N ботов, которые входят, по расписанию заходят в лобби
+//и делают случайные допустимые ходы с заданным временем обдумывания. Выводит пропускную способность,
+//перцентили задержек, ошибки и зависшие игры. Логины ботов должны быть в списке участников сервера, а
+//ограничения подключений сервера (maxConnections, maxConnectionsPerIP, rateLimits) должны их пропускать:
+//
+//	go run ./loadtest -addr localhost:5703 -prefix bot -bots 200 -think exp:300ms
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goServer/client"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:5703", "server address")
+	logins   = flag.String("logins", "resources/participants_list", "file with bot logins, one per line")
+	prefix   = flag.String("prefix", "", "use logins <prefix>1..<prefix>N instead of the logins file")
+	bots     = flag.Int("bots", 0, "number of bots, 0 - one per login in the file")
+	games    = flag.Int("games", 0, "games per bot, 0 - until the bot has no scheduled games")
+	think    = flag.String("think", "uniform:0:200ms", "think time: fixed:D, uniform:MIN:MAX, exp:MEAN or normal:MEAN:STDDEV")
+	barriers = flag.Float64("barriers", 0.2, "probability that a move places a barrier")
+	stuck    = flag.Duration("stuck", time.Minute, "a game without events for this long is stuck")
+	ramp     = flag.Duration("ramp", 10*time.Millisecond, "delay between bot starts")
+	duration = flag.Duration("duration", 0, "stop after this time, 0 - when all bots are done")
+	interval = flag.Duration("report", 10*time.Second, "progress report interval")
+	seed     = flag.Int64("seed", 1, "seed of bot moves and think times")
+)
+
+//Сколько раз повторять команду, на которую сервер ответил THROTTLED
+const throttleRetries = 5
+
+//Сколько раз подряд бот пробует зайти в лобби после ошибки, прежде чем сдаться
+const joinRetries = 3
+
+func main() {
+	flag.Parse()
+	names, err := botNames()
+	if err == nil {
+		sample, err = parseThink(*think)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	fmt.Printf("%d bots against %s, think time %s\n", len(names), *addr, *think)
+	var done = make(chan struct{})
+	var wait sync.WaitGroup
+	go func() {
+		for i, name := range names {
+			wait.Add(1)
+			go func(i int, name string) {
+				defer wait.Done()
+				runBot(i, name)
+			}(i, name)
+			time.Sleep(*ramp)
+		}
+		wait.Wait()
+		close(done)
+	}()
+	var timeout <-chan time.Time
+	if *duration > 0 {
+		timeout = time.After(*duration)
+	}
+	var ticker = time.NewTicker(*interval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			stats.progress()
+		case <-done:
+			break loop
+		case <-timeout:
+			fmt.Println("duration elapsed, stopping")
+			break loop
+		}
+	}
+	if !stats.report() {
+		os.Exit(1)
+	}
+}
+
+//Логины ботов: prefix1..prefixN или первые N строк файла logins
+func botNames() ([]string, error) {
+	var res []string
+	if *prefix != "" {
+		if *bots <= 0 {
+			return nil, errors.New("-bots is required with -prefix")
+		}
+		for i := 1; i <= *bots; i++ {
+			res = append(res, *prefix+strconv.Itoa(i))
+		}
+		return res, nil
+	}
+	data, err := os.ReadFile(*logins)
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range strings.Split(string(data), "\n") {
+		if val = strings.TrimSpace(val); val != "" {
+			res = append(res, val)
+		}
+	}
+	if *bots > len(res) {
+		return nil, fmt.Errorf("%d bots requested, but %s has only %d logins", *bots, *logins, len(res))
+	}
+	if *bots > 0 {
+		res = res[:*bots]
+	}
+	return res, nil
+}
+
+//Распределение времени обдумывания
+var sample func(rnd *rand.Rand) time.Duration
+
+//Разбирает распределение времени обдумывания: fixed:D, uniform:MIN:MAX, exp:MEAN или normal:MEAN:STDDEV
+func parseThink(str string) (func(rnd *rand.Rand) time.Duration, error) {
+	var parts = strings.Split(str, ":")
+	var args = make([]time.Duration, len(parts)-1)
+	for i, val := range parts[1:] {
+		var err error
+		if val == "0" {
+			continue
+		}
+		if args[i], err = time.ParseDuration(val); err != nil {
+			return nil, fmt.Errorf("think time %q: %w", str, err)
+		}
+	}
+	var positive = func(d float64) time.Duration {
+		return time.Duration(math.Max(d, 0))
+	}
+	switch {
+	case parts[0] == "fixed" && len(args) == 1:
+		return func(*rand.Rand) time.Duration { return args[0] }, nil
+	case parts[0] == "uniform" && len(args) == 2 && args[0] <= args[1]:
+		return func(rnd *rand.Rand) time.Duration {
+			return args[0] + positive(rnd.Float64()*float64(args[1]-args[0]))
+		}, nil
+	case parts[0] == "exp" && len(args) == 1:
+		return func(rnd *rand.Rand) time.Duration { return positive(rnd.ExpFloat64() * float64(args[0])) }, nil
+	case parts[0] == "normal" && len(args) == 2:
+		return func(rnd *rand.Rand) time.Duration {
+			return positive(float64(args[0]) + rnd.NormFloat64()*float64(args[1]))
+		}, nil
+	}
+	return nil, fmt.Errorf("think time %q: expected fixed:D, uniform:MIN:MAX, exp:MEAN or normal:MEAN:STDDEV", str)
+}
+
+//Статистика нагрузки
+type loadStats struct {
+	mutex     sync.Mutex
+	start     time.Time
+	latencies map[string][]time.Duration //Задержки ответов на команды и пересылки ходов; join - вместе с ожиданием игры
+	errors    map[string]int             //Ошибки по кодам сервера и видам сбоев
+	reasons   map[string]int             //Причины окончания игр
+	started   sync.Map                   //Начатые игры по ID лобби
+	finished  sync.Map                   //Законченные игры по ID лобби
+	stuck     sync.Map                   //Зависшие игры по ID лобби
+	moves     atomic.Int64
+	active    atomic.Int64 //Подключенные боты
+}
+
+var stats = &loadStats{
+	start:     time.Now(),
+	latencies: make(map[string][]time.Duration),
+	errors:    make(map[string]int),
+	reasons:   make(map[string]int),
+}
+
+//Время отправки последнего хода в каждом лобби, чтобы противник мог измерить, за сколько сервер переслал ход
+var moveSent sync.Map
+
+//Записывает задержку вида kind
+func (s *loadStats) observe(kind string, d time.Duration) {
+	s.mutex.Lock()
+	s.latencies[kind] = append(s.latencies[kind], d)
+	s.mutex.Unlock()
+}
+
+//Записывает ошибку вида kind
+func (s *loadStats) fail(kind string) {
+	s.mutex.Lock()
+	s.errors[kind] += 1
+	s.mutex.Unlock()
+}
+
+//Количество записей в sync.Map
+func count(m *sync.Map) int {
+	var res = 0
+	m.Range(func(_, _ any) bool {
+		res += 1
+		return true
+	})
+	return res
+}
+
+//Выводит одну строку о ходе проверки
+func (s *loadStats) progress() {
+	var elapsed = time.Since(s.start)
+	s.mutex.Lock()
+	var errs = 0
+	for _, val := range s.errors {
+		errs += val
+	}
+	s.mutex.Unlock()
+	fmt.Printf("%6.0fs  bots %d  games %d started, %d finished, %d stuck  moves %d (%.1f/s)  errors %d\n",
+		elapsed.Seconds(), s.active.Load(), count(&s.started), count(&s.finished), count(&s.stuck),
+		s.moves.Load(), float64(s.moves.Load())/elapsed.Seconds(), errs)
+}
+
+//Выводит итоговый отчёт. Возвращает false, если были зависшие игры или боты не смогли войти
+func (s *loadStats) report() bool {
+	var elapsed = time.Since(s.start)
+	s.progress()
+	fmt.Printf("\nthroughput: %.2f moves/s, %.2f games/min\n", float64(s.moves.Load())/elapsed.Seconds(),
+		float64(count(&s.finished))/elapsed.Minutes())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Printf("\n%-10s %8s %10s %10s %10s %10s\n", "latency", "count", "p50", "p90", "p99", "max")
+	for _, kind := range sortedKeys(s.latencies) {
+		var val = s.latencies[kind]
+		sort.Slice(val, func(i, j int) bool { return val[i] < val[j] })
+		fmt.Printf("%-10s %8d %10s %10s %10s %10s\n", kind, len(val), percentile(val, 0.5), percentile(val, 0.9),
+			percentile(val, 0.99), val[len(val)-1])
+	}
+	if len(s.reasons) > 0 {
+		fmt.Println("\ngame endings (per player):")
+		for _, reason := range sortedKeys(s.reasons) {
+			fmt.Printf("  %-12s %d\n", reason, s.reasons[reason])
+		}
+	}
+	if len(s.errors) > 0 {
+		fmt.Println("\nerrors:")
+		for _, kind := range sortedKeys(s.errors) {
+			fmt.Printf("  %-20s %d\n", kind, s.errors[kind])
+		}
+	}
+	if s.errors[client.ErrTooManyConnections] > 0 {
+		fmt.Println("\nthe server rejected connections: raise maxConnections and maxConnectionsPerIP")
+	}
+	return count(&s.stuck) == 0 && s.errors["connect"] == 0 && s.errors["login"] == 0
+}
+
+//Ключи map по алфавиту
+func sortedKeys[T any](m map[string]T) []string {
+	var res = make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+//Перцентиль p отсортированных задержек
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	var i = int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
+
+//Бот
+type bot struct {
+	name   string
+	conn   net.Conn
+	reader *bufio.Reader
+	rnd    *rand.Rand
+}
+
+//Ошибка чтения, после которой бот заканчивает работу
+var errStuck = errors.New("no events from the server")
+
+//Подключает бота name, входит и играет, пока не закончатся игры
+func runBot(i int, name string) {
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		stats.fail("connect")
+		return
+	}
+	stats.active.Add(1)
+	defer stats.active.Add(-1)
+	var b = &bot{name: name, conn: conn, reader: bufio.NewReader(conn), rnd: rand.New(rand.NewSource(*seed + int64(i)))}
+	defer b.conn.Close()
+	if _, code, err := b.command("CONNECTION", fmt.Sprintf(`{"LOGIN":%q}`, name), "login"); err != nil || code != "" {
+		stats.fail("login")
+		return
+	}
+	var failures = 0
+	for played := 0; *games == 0 || played < *games; played++ {
+		reply, code, err := b.command("SOCKET JOINLOBBY", `{"id":null}`, "join")
+		if err != nil || code == client.ErrNoScheduledGame {
+			break
+		}
+		var join client.JoinLobbyResponse
+		if code != "" || json.Unmarshal([]byte(reply), &join) != nil || !join.Success || join.Data.ID == nil {
+			if failures += 1; failures > joinRetries {
+				break
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		failures = 0
+		if err = b.play(join.Data); err != nil {
+			break
+		}
+	}
+	_ = b.send("DISCONNECT", "")
+}
+
+//Отправляет команду
+func (b *bot) send(command, payload string) error {
+	var line = command
+	if payload != "" {
+		line += " " + payload
+	}
+	_, err := b.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+//Читает строку от сервера. Если строки нет дольше stuck, то возвращает errStuck
+func (b *bot) read() (string, error) {
+	_ = b.conn.SetReadDeadline(time.Now().Add(*stuck))
+	line, err := b.reader.ReadString('\n')
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return "", errStuck
+	}
+	if err != nil {
+		stats.fail("read")
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+//Отправляет команду, ждёт ответ и записывает задержку вида kind. Возвращает ответ и код ошибки сервера, если
+//он ответил ошибкой. На THROTTLED ждёт и повторяет команду
+func (b *bot) command(command, payload, kind string) (string, string, error) {
+	for i := 0; ; i++ {
+		var sent = time.Now()
+		if err := b.send(command, payload); err != nil {
+			stats.fail("write")
+			return "", "", err
+		}
+		reply, err := b.read()
+		if err != nil {
+			if err == errStuck {
+				stats.fail("no_reply")
+			}
+			return "", "", err
+		}
+		stats.observe(kind, time.Since(sent))
+		var response client.ErrorResponse
+		if json.Unmarshal([]byte(reply), &response) != nil || response.Error.Code == "" {
+			return reply, "", nil
+		}
+		if response.Error.Code != client.ErrNoScheduledGame {
+			stats.fail(response.Error.Code)
+		}
+		if response.Error.Code != client.ErrThrottled || i == throttleRetries {
+			return reply, response.Error.Code, nil
+		}
+		time.Sleep(time.Duration(response.Error.RetryAfter) * time.Millisecond)
+	}
+}
+
+//Играет одну игру в лобби info: ждёт её начала, ходит в свою очередь и ждёт конца. Возвращает ошибку, если
+//соединение оборвалось или игра зависла
+func (b *bot) play(info client.LobbyInfo) error {
+	var id = *info.ID
+	line, err := b.read()
+	if err == errStuck {
+		stats.fail("stuck_start")
+		stats.stuck.Store(id, true)
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "SOCKET STARTGAME ") {
+		stats.fail("unexpected")
+		return errors.New("SOCKET STARTGAME expected, got " + line)
+	}
+	var payload = strings.TrimPrefix(line, "SOCKET STARTGAME ")
+	var start client.StartGame
+	var view client.Field
+	_ = json.Unmarshal([]byte(payload), &start)
+	_ = json.Unmarshal([]byte(payload), &view)
+	stats.started.Store(id, true)
+	var strategy = client.RandomStrategy(b.rnd, info, *barriers)
+	var myTurn = start.Move
+	for {
+		if myTurn {
+			time.Sleep(sample(b.rnd))
+			data, _ := json.Marshal(strategy(view))
+			moveSent.Store(id, time.Now())
+			if err = b.send("SOCKET STEP", string(data)); err != nil {
+				stats.fail("write")
+				return err
+			}
+			stats.moves.Add(1)
+			myTurn = false
+		}
+		line, err = b.read()
+		if err == errStuck {
+			stats.fail("stuck_game")
+			stats.stuck.Store(id, true)
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "SOCKET STEP "):
+			if sent, ok := moveSent.Load(id); ok {
+				stats.observe("relay", time.Since(sent.(time.Time)))
+			}
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "SOCKET STEP ")), &view)
+			myTurn = true
+		case strings.HasPrefix(line, "SOCKET ENDGAME "):
+			var end client.EndGame
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "SOCKET ENDGAME ")), &end)
+			stats.mutex.Lock()
+			stats.reasons[end.Reason] += 1
+			stats.mutex.Unlock()
+			stats.finished.Store(id, true)
+			moveSent.Delete(id)
+			return nil
+		default:
+			//В тумане войны ход, который упёрся в невидимого игрока или препятствие, нужно повторить
+			var response client.ErrorResponse
+			if json.Unmarshal([]byte(line), &response) == nil && response.Error.Code == client.ErrHiddenConflict {
+				stats.fail(response.Error.Code)
+				myTurn = true
+				continue
+			}
+			stats.fail("unexpected")
+		}
+	}
+}
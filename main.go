@@ -1,7 +1,16 @@
 package main
 
-import "goServer/server"
+import (
+	"fmt"
+	"goServer/server"
+	"os"
+)
 
 func main() {
-	server.Server.Start()
+	s, err := server.NewServer()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	s.Start()
 }
@@ -0,0 +1,258 @@
+//Проверка JSON по спецификации протокола. Спецификация - это документ JSON Schema, в $defs которого описаны
+//все данные протокола, а в commands и events - команды клиента и события сервера. Поддерживается подмножество
+//JSON Schema, которого хватает протоколу: $ref на #/$defs, type, enum, properties, required,
+//additionalProperties, items, minItems, maxItems, minimum, maximum, minLength и maxLength
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//Ошибка, которую возвращает Validate, если определения нет в спецификации
+var ErrUndefined = errors.New("undefined definition")
+
+//Команда клиента
+type Command struct {
+	Payload  string `json:"payload"`  //Нужны ли команде данные: required, optional или none
+	Request  string `json:"request"`  //Определение данных команды в $defs
+	Response string `json:"response"` //Определение ответа в $defs. Пустое, если сервер не отвечает
+}
+
+//Событие, которое сервер отправляет сам
+type Event struct {
+	Payload string `json:"payload"` //Определение данных события в $defs
+}
+
+//Спецификация протокола
+type Spec struct {
+	Commands map[string]Command `json:"commands"`
+	Events   map[string]Event   `json:"events"`
+	defs     map[string]any
+}
+
+//Читает спецификацию из файла path
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+//Разбирает спецификацию и проверяет, что все команды и события ссылаются на существующие определения
+func Parse(data []byte) (*Spec, error) {
+	var res Spec
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Defs map[string]any `json:"$defs"`
+	}
+	if err := decode(data, &doc); err != nil {
+		return nil, err
+	}
+	res.defs = doc.Defs
+	var names []string
+	for name, val := range res.Commands {
+		names = append(names, val.Request, val.Response)
+		if val.Payload != "required" && val.Payload != "optional" && val.Payload != "none" {
+			return nil, fmt.Errorf("command %s: unknown payload kind %q", name, val.Payload)
+		}
+	}
+	for _, val := range res.Events {
+		names = append(names, val.Payload)
+	}
+	for _, name := range names {
+		if _, ok := res.defs[name]; name != "" && !ok {
+			return nil, fmt.Errorf("undefined definition %q", name)
+		}
+	}
+	return &res, nil
+}
+
+//Проверяет данные data по определению def
+func (s *Spec) Validate(def string, data []byte) error {
+	if _, ok := s.defs[def]; !ok {
+		return fmt.Errorf("%w %q", ErrUndefined, def)
+	}
+	var value any
+	if err := decode(data, &value); err != nil {
+		return err
+	}
+	return s.validate(s.defs[def], value, "$")
+}
+
+//Разбирает JSON, сохраняя числа в виде json.Number, чтобы отличать целые от дробных
+func decode(data []byte, v any) error {
+	var decoder = json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+//Проверяет значение value по схеме schema. path - путь к значению для сообщений об ошибках
+func (s *Spec) validate(schema any, value any, path string) error {
+	var rules, ok = schema.(map[string]any)
+	if !ok {
+		//true или отсутствующая схема разрешают всё, false - ничего
+		if schema == false {
+			return fmt.Errorf("%s: not allowed", path)
+		}
+		return nil
+	}
+	if ref, ok := rules["$ref"].(string); ok {
+		var def = strings.TrimPrefix(ref, "#/$defs/")
+		if _, ok := s.defs[def]; !ok {
+			return fmt.Errorf("%s: undefined definition %q", path, ref)
+		}
+		return s.validate(s.defs[def], value, path)
+	}
+	if types, ok := rules["type"]; ok && !matchesType(types, value) {
+		return fmt.Errorf("%s: must be %s, got %s", path, typeNames(types), typeOf(value))
+	}
+	if enum, ok := rules["enum"].([]any); ok {
+		var found = false
+		for _, val := range enum {
+			if fmt.Sprint(val) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v, got %v", path, enum, value)
+		}
+	}
+	switch val := value.(type) {
+	case map[string]any:
+		return s.validateObject(rules, val, path)
+	case []any:
+		return s.validateArray(rules, val, path)
+	case string:
+		var length = utf8.RuneCountInString(val)
+		if limit, ok := number(rules["minLength"]); ok && float64(length) < limit {
+			return fmt.Errorf("%s: must be at least %v characters long", path, limit)
+		}
+		if limit, ok := number(rules["maxLength"]); ok && float64(length) > limit {
+			return fmt.Errorf("%s: must be at most %v characters long", path, limit)
+		}
+	case json.Number:
+		var num, _ = val.Float64()
+		if limit, ok := number(rules["minimum"]); ok && num < limit {
+			return fmt.Errorf("%s: must be at least %v", path, limit)
+		}
+		if limit, ok := number(rules["maximum"]); ok && num > limit {
+			return fmt.Errorf("%s: must be at most %v", path, limit)
+		}
+	}
+	return nil
+}
+
+func (s *Spec) validateObject(rules map[string]any, value map[string]any, path string) error {
+	var properties, _ = rules["properties"].(map[string]any)
+	if required, ok := rules["required"].([]any); ok {
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+	}
+	var names = make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var propertyPath = path + "." + name
+		if schema, ok := properties[name]; ok {
+			if err := s.validate(schema, value[name], propertyPath); err != nil {
+				return err
+			}
+		} else if additional, ok := rules["additionalProperties"]; ok {
+			if err := s.validate(additional, value[name], propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Spec) validateArray(rules map[string]any, value []any, path string) error {
+	if limit, ok := number(rules["minItems"]); ok && float64(len(value)) < limit {
+		return fmt.Errorf("%s: must have at least %v items", path, limit)
+	}
+	if limit, ok := number(rules["maxItems"]); ok && float64(len(value)) > limit {
+		return fmt.Errorf("%s: must have at most %v items", path, limit)
+	}
+	if items, ok := rules["items"]; ok {
+		for i, val := range value {
+			if err := s.validate(items, val, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//Проверяет, подходит ли значение под type схемы: одно название типа или массив названий
+func matchesType(types any, value any) bool {
+	var names, ok = types.([]any)
+	if !ok {
+		names = []any{types}
+	}
+	var actual = typeOf(value)
+	for _, name := range names {
+		if name == actual || name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+//Тип значения в терминах JSON Schema
+func typeOf(value any) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func typeNames(types any) string {
+	if names, ok := types.([]any); ok {
+		var res = make([]string, len(names))
+		for i, val := range names {
+			res[i] = fmt.Sprint(val)
+		}
+		return strings.Join(res, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+//Число из схемы
+func number(value any) (float64, bool) {
+	var num, ok = value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	var res, err = num.Float64()
+	return res, err == nil
+}
@@ -0,0 +1,170 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+//Небольшая спецификация, в которой есть все поддерживаемые правила
+const testSpec = `{
+  "commands": {
+    "PUT": {"payload": "required", "request": "Item", "response": "Items"}
+  },
+  "events": {
+    "ADDED": {"payload": "Item"}
+  },
+  "$defs": {
+    "Name": {"type": "string", "minLength": 1, "maxLength": 5},
+    "Item": {
+      "type": "object",
+      "required": ["name", "kind"],
+      "additionalProperties": false,
+      "properties": {
+        "name": {"$ref": "#/$defs/Name"},
+        "kind": {"enum": ["a", "b"]},
+        "count": {"type": "integer", "minimum": 0, "maximum": 10},
+        "weight": {"type": ["number", "null"]},
+        "cells": {
+          "type": ["array", "null"],
+          "items": {"type": "array", "minItems": 2, "maxItems": 2, "items": {"type": "integer"}}
+        },
+        "tags": {"type": "object", "additionalProperties": {"type": "boolean"}}
+      }
+    },
+    "Items": {"type": "array", "items": {"$ref": "#/$defs/Item"}},
+    "Anything": true
+  }
+}`
+
+func parseTestSpec(t *testing.T) *Spec {
+	t.Helper()
+	var spec, err = Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("can't parse the test specification: %v", err)
+	}
+	return spec
+}
+
+func TestValidate(t *testing.T) {
+	var spec = parseTestSpec(t)
+	var tests = []struct {
+		name string
+		def  string
+		data string
+		err  string //Часть сообщения об ошибке, пустая, если данные подходят
+	}{
+		{"minimal object", "Item", `{"name":"x","kind":"a"}`, ""},
+		{"all properties", "Item", `{"name":"x","kind":"b","count":3,"weight":1.5,"cells":[[1,2],[3,4]],"tags":{"t":true}}`, ""},
+		{"null allowed by type list", "Item", `{"name":"x","kind":"a","weight":null,"cells":null}`, ""},
+		{"not an object", "Item", `[1]`, "$: must be object, got array"},
+		{"wrong property type", "Item", `{"name":1,"kind":"a"}`, "$.name: must be string, got integer"},
+		{"fraction instead of integer", "Item", `{"name":"x","kind":"a","count":1.5}`, "$.count: must be integer, got number"},
+		{"integer is a number", "Item", `{"name":"x","kind":"a","weight":2}`, ""},
+		{"missing required", "Item", `{"name":"x"}`, `$: missing required property "kind"`},
+		{"unknown property", "Item", `{"name":"x","kind":"a","extra":1}`, "$.extra: not allowed"},
+		{"additional properties schema", "Item", `{"name":"x","kind":"a","tags":{"t":1}}`, "$.tags.t: must be boolean, got integer"},
+		{"not in enum", "Item", `{"name":"x","kind":"c"}`, "$.kind: must be one of [a b], got c"},
+		{"too short", "Item", `{"name":"","kind":"a"}`, "$.name: must be at least 1 characters long"},
+		{"too long", "Item", `{"name":"привет!","kind":"a"}`, "$.name: must be at most 5 characters long"},
+		{"below minimum", "Item", `{"name":"x","kind":"a","count":-1}`, "$.count: must be at least 0"},
+		{"above maximum", "Item", `{"name":"x","kind":"a","count":11}`, "$.count: must be at most 10"},
+		{"nested array item type", "Item", `{"name":"x","kind":"a","cells":[[1,2],[3,"4"]]}`, "$.cells[1][1]: must be integer, got string"},
+		{"nested array too short", "Item", `{"name":"x","kind":"a","cells":[[1]]}`, "$.cells[0]: must have at least 2 items"},
+		{"nested array too long", "Item", `{"name":"x","kind":"a","cells":[[1,2,3]]}`, "$.cells[0]: must have at most 2 items"},
+		{"array of references", "Items", `[{"name":"x","kind":"a"},{"name":"y"}]`, `$[1]: missing required property "kind"`},
+		{"true allows anything", "Anything", `{"any":[1,"2",null]}`, ""},
+		{"invalid JSON", "Item", `{"name":`, "unexpected EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err = spec.Validate(test.def, []byte(test.data))
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("Validate(%s, %s) = %v, want no error", test.def, test.data, err)
+			case test.err != "" && err == nil:
+				t.Errorf("Validate(%s, %s) = nil, want an error with %q", test.def, test.data, test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("Validate(%s, %s) = %v, want an error with %q", test.def, test.data, err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateUndefined(t *testing.T) {
+	var spec = parseTestSpec(t)
+	if err := spec.Validate("Missing", []byte(`{}`)); !errors.Is(err, ErrUndefined) {
+		t.Errorf("Validate(Missing) = %v, want ErrUndefined", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"undefined request", `{"commands":{"PUT":{"payload":"required","request":"Item"}},"$defs":{}}`, `undefined definition "Item"`},
+		{"undefined event", `{"events":{"ADDED":{"payload":"Item"}},"$defs":{}}`, `undefined definition "Item"`},
+		{"unknown payload kind", `{"commands":{"PUT":{"payload":"sometimes"}},"$defs":{}}`, `unknown payload kind "sometimes"`},
+		{"not JSON", `commands`, "invalid character"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse([]byte(test.data)); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Parse(%s) = %v, want an error with %q", test.data, err, test.err)
+			}
+		})
+	}
+}
+
+//Спецификация протокола сервера должна читаться и описывать то, что сервер отправляет и принимает
+func TestProtocol(t *testing.T) {
+	var spec, err = Load("../resources/protocol.schema.json")
+	if err != nil {
+		t.Fatalf("can't load the protocol specification: %v", err)
+	}
+	const lobby = `{"_id":"1","width":5,"height":5,"gameBarrierCount":2,"playerBarrierCount":2,"name":"a_vs_b_1",` +
+		`"players_count":2,"timeControl":"fischer","timeBank":0,"increment":0,"delay":0,"seed":7,"generator":"random",` +
+		`"tolerance":1,"distance":4,"opponentDistance":4,"pair":"","firstPlayer":"","jumps":false,"teams":null,` +
+		`"variant":"classic","visibility":0}`
+	const field = `{"width":5,"height":5,"position":[0,2],"opponentPosition":[4,2],"barriers":[[[1,1],[1,2]]],` +
+		`"positions":[[0,2],[4,2]],"goals":["bottom","top"],"barriersLeft":2,"timeLeft":0,"opponentTimeLeft":0,` +
+		`"timesLeft":[0,0]}`
+	var tests = []struct {
+		name string
+		def  string
+		data string
+		ok   bool
+	}{
+		{"lobby", "LobbyInfo", lobby, true},
+		{"lobby without a property", "LobbyInfo", strings.Replace(lobby, `"seed":7,`, "", 1), false},
+		{"lobby with an unknown property", "LobbyInfo", strings.Replace(lobby, `"seed":7`, `"seed":7,"colour":"red"`, 1), false},
+		{"lobby with an unknown variant", "LobbyInfo", strings.Replace(lobby, `"classic"`, `"chess"`, 1), false},
+		{"lobby list", "GetLobbyResponse", `{"DATA":[` + lobby + `],"SUCCESS":true}`, true},
+		{"lobby request", "LobbyRequest", `{"width":5,"height":5,"REQ_ID":1}`, true},
+		{"lobby request without size", "LobbyRequest", `{"gameBarrierCount":2}`, false},
+		{"field request by id", "FieldRequest", `{"_id":"1"}`, true},
+		{"field request with an unknown property", "FieldRequest", `{"_id":"1","size":5}`, false},
+		{"field", "Field", field, true},
+		{"field with a reply id", "Field", strings.Replace(field, `{`, `{"REQ_ID":"r",`, 1), true},
+		{"field without barriers", "Field", strings.Replace(field, `"barriers":[[[1,1],[1,2]]],`, "", 1), false},
+		{"field with a wrong cell", "Field", strings.Replace(field, `[[1,1],[1,2]]`, `[[1,1],[1]]`, 1), false},
+		{"field with an unknown property", "Field", strings.Replace(field, `{`, `{"turn":3,`, 1), false},
+		{"move", "Move", `{"position":[1,2],"opponentPosition":[4,2],"barriers":[]}`, true},
+		{"move without barriers", "Move", `{"position":[1,2],"opponentPosition":[4,2]}`, false},
+		{"unknown error code", "ErrorResponse", `{"ERROR":{"code":"OOPS","message":""}}`, false},
+		{"error", "ErrorResponse", `{"ERROR":{"code":"THROTTLED","message":"","retryAfter":100}}`, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err = spec.Validate(test.def, []byte(test.data))
+			if test.ok && err != nil {
+				t.Errorf("Validate(%s) = %v, want no error", test.def, err)
+			}
+			if !test.ok && err == nil {
+				t.Errorf("Validate(%s) = nil, want an error", test.def)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+//Состояние отложенной игры, из которого её можно продолжить
+type adjournedGame struct {
+	Lobby        string   `json:"lobby"`        //ID лобби
+	Players      []string `json:"players"`      //Игроки в порядке ходов
+	Field        Field    `json:"field"`        //Настоящее поле, без тумана войны
+	Turn         int      `json:"turn"`         //Номер хода, который предстоит сделать. Ходит игрок Turn % len(Players)
+	BarriersLeft []uint8  `json:"barriersLeft"` //Оставшиеся препятствия игроков или команд
+	TimesLeft    []uint32 `json:"timesLeft"`    //Оставшееся время игроков в миллисекундах
+}
+
+//Просит игру в лобби отложиться. Игра откладывается, как только закончится ожидание текущего хода
+func (l *Lobby) adjournGame() {
+	l.adjournOnce.Do(func() {
+		close(l.adjourn)
+	})
+}
+
+//Проверяет, просили ли отложить игру
+func (l *Lobby) adjourned() bool {
+	select {
+	case <-l.adjourn:
+		return true
+	default:
+		return false
+	}
+}
+
+//Сообщает игрокам, что игра отложена, сохраняет лог и отправляет состояние игры в канал результатов
+func (l *Lobby) adjournPlayers() {
+	var g = l.state
+	var state = adjournedGame{
+		Lobby:        *l.Info.ID,
+		Players:      g.names,
+		Field:        g.field,
+		Turn:         g.turn,
+		BarriersLeft: append([]uint8{}, g.budget.left...),
+		TimesLeft:    make([]uint32, len(g.players)),
+	}
+	for i := range g.players {
+		state.TimesLeft[i] = g.clock.left(i)
+	}
+	l.log().Info("game adjourned", "players", g.names, "turn", g.turn)
+	l.server.metrics.gameFinished(ReasonAdjourned)
+	l.results <- result{reason: ReasonAdjourned, players: g.names, timings: g.timings, adjourned: &state}
+	for i, val := range g.players {
+		var view = g.field.view(i, g.clock, g.budget, l.Info.visibility())
+		var endGame = EndGameInfo{
+			Result:           "adjourned",
+			Reason:           ReasonAdjourned,
+			Width:            view.Width,
+			Height:           view.Height,
+			Position:         view.Position,
+			OpponentPosition: view.OpponentPosition,
+			Barriers:         view.Barriers,
+			Positions:        view.Positions,
+			Goals:            view.Goals,
+			Visible:          view.Visible,
+			BarriersLeft:     view.BarriersLeft,
+			TimeLeft:         view.TimeLeft,
+			OpponentTimeLeft: view.OpponentTimeLeft,
+			TimesLeft:        view.TimesLeft,
+			MoveTimes:        playerTimes(g.timings, i),
+		}
+		data, _ := json.Marshal(endGame)
+		val.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(data))))
+	}
+	var re = regexp.MustCompile("<!--RESULT-->")
+	g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Игра отложена на ходу %d", g.turn+1)))
+	l.writeLogFile()
+}
+
+//Продолжает отложенную игру saved. players - клиенты игроков в порядке ходов
+func (l *Lobby) resumeGame(players []*connectedClient, saved adjournedGame) {
+	var limits = l.server.currentLimits()
+	var state = new(gameState)
+	*state = gameState{
+		players: players,
+		names:   saved.Players,
+		field:   saved.Field,
+		clock:   newChessClock(l.Info, len(players), limits.timeout),
+		budget:  newBarrierBudget(l.Info, len(players)),
+		turn:    saved.Turn,
+		draws:   newDrawTracker(saved.Field, saved.Turn%len(players), saved.Turn, l.Info.rules(), limits),
+		limits:  limits,
+		log:     l.initLog(saved.Players, l.Info.teamGame(), l.Info.Variant),
+	}
+	copy(state.budget.left, saved.BarriersLeft)
+	if state.clock.banked {
+		for i, val := range saved.TimesLeft {
+			state.clock.remaining[i] = time.Duration(val) * time.Millisecond
+		}
+	}
+	var re = regexp.MustCompile("<!--TURNS-->")
+	state.log = re.ReplaceAll(state.log, []byte("<p>Продолжение отложенной игры</p>\n<!--TURNS-->"))
+	l.state = state
+	l.game = fmt.Sprintf("%s_%s", *l.Info.ID, l.server.clock.Now().Format(time.StampMicro))
+	l.log().Info("game resumed", "players", saved.Players, "turn", saved.Turn)
+	l.server.metrics.gamesStarted.Add(1)
+	l.continueGame()
+}
+
+//Сохраняет состояние отложенной игры в БД. Лобби при этом остаётся в таблице lobbies
+func (s *server) saveAdjourned(game string, state *adjournedGame) {
+	data, _ := json.Marshal(state)
+	if err := s.store.SaveAdjourned(game, state.Lobby, string(data)); err != nil {
+		s.logger.Error("can't save adjourned game", "game", game, "err", err)
+	}
+}
+
+//Откладывает идущую в лобби id игру
+func (s *server) adjournLobby(id int) error {
+	s.lobbiesMutex.Lock()
+	defer s.lobbiesMutex.Unlock()
+	var lobby, ok = s.playingLobbies[uint(id)]
+	if !ok || lobby == nil || !lobby.isPlaying {
+		return errors.New("no game is running in lobby " + strconv.Itoa(id))
+	}
+	lobby.adjournGame()
+	return nil
+}
+
+//Продолжает последнюю отложенную игру лобби id. Все её игроки должны быть подключены и не должны находиться в
+//других лобби
+func (s *server) resumeLobby(id int) error {
+	if !s.active.Load() {
+		return errors.New("server is shutting down")
+	}
+	info, err := s.getLobby(id)
+	if err != nil {
+		return err
+	}
+	game, data, found, err := s.store.LastAdjourned(id)
+	if err == nil && !found {
+		err = errors.New("no adjourned game in lobby " + strconv.Itoa(id))
+	}
+	if err != nil {
+		return err
+	}
+	var saved adjournedGame
+	if err = json.Unmarshal([]byte(data), &saved); err != nil {
+		return err
+	}
+	s.lobbiesMutex.Lock()
+	defer s.lobbiesMutex.Unlock()
+	if lobby, ok := s.playingLobbies[uint(id)]; ok && lobby != nil {
+		return errors.New("lobby " + strconv.Itoa(id) + " is busy")
+	}
+	s.clientsMapMutex.Lock()
+	defer s.clientsMapMutex.Unlock()
+	var players = make([]*connectedClient, len(saved.Players))
+	for i, name := range saved.Players {
+		for c, lobby := range s.connectedClient {
+			if c.name == name && c.active && lobby == nil {
+				players[i] = c
+			}
+		}
+		if players[i] == nil {
+			return errors.New("player " + name + " is not connected or is in another lobby")
+		}
+	}
+	var lobby = s.newLobby(info)
+	lobby.isPlaying = true
+	s.playingLobbies[uint(id)] = lobby
+	for _, val := range players {
+		s.connectedClient[val] = lobby
+	}
+	s.startGame(lobby, players, func() {
+		lobby.resumeGame(players, saved)
+		//Пока игра не закончилась, лобби остаётся отмеченным как отложенное, чтобы в него не зашли по расписанию
+		if err := s.store.DeleteAdjourned(game); err != nil {
+			lobby.log().Error("can't delete adjourned game", "game", game, "err", err)
+		}
+	})
+	return nil
+}
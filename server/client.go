@@ -2,18 +2,29 @@ package server
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"sync"
 )
 
+//Наибольшая длина строки от клиента в байтах. Клиент, приславший строку длиннее, отключается
+const MaxLineLength = 1 << 20
+
 //Структура подключенного клиента
 type connectedClient struct {
-	conn                  net.Conn   //Соединение, по которому осуществляется общение с клиентом
-	name                  string     //Логин клиента, аналогичен записи в БД
-	dataReceivedListeners *FuncStack //Стек функций, вызываемых при получении сообщения
-	active                bool       //Идёт ли общение с данным клиентом
-	readMutex             sync.Mutex //мьютекс, который приостанавливает чтение из потока входящих сообщений
+	conn                  net.Conn        //Соединение, по которому осуществляется общение с клиентом
+	name                  string          //Логин клиента, аналогичен записи в БД
+	dataReceivedListeners *FuncStack      //Стек функций, вызываемых при получении сообщения
+	active                bool            //Идёт ли общение с данным клиентом
+	readMutex             sync.Mutex      //мьютекс, который приостанавливает чтение из потока входящих сообщений
+	limiter               *rateLimiter    //Ограничение частоты запросов по этому соединению
+	reqID                 json.RawMessage //REQ_ID команды, которая сейчас обрабатывается, или nil
+	server                *server         //Сервер, к которому подключен клиент
 }
 
 //Запускает общение с клиентом, начиная прослушивать от него сообщения
@@ -22,23 +33,41 @@ func (c *connectedClient) StartCommunicator() {
 	go c.communicate()
 }
 
-//Основная функция, которая получает сообщения и вызывает функции из стека
+//Основная функция, которая получает сообщения и вызывает функции из стека. Каждая строка - отдельная команда,
+//поэтому команды, пришедшие одним пакетом, обрабатываются по очереди
 func (c *connectedClient) communicate() {
 	defer c.conn.Close()
+	var scanner = bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 1024*4), MaxLineLength)
 	for c.active {
-		input := make([]byte, 1024*4)
-		n, err := c.conn.Read(input)
-		c.readMutex.Lock()
-		if n == 0 || err != nil {
-			fmt.Println("Read error:", err)
-			Server.disconnect(c)
+		if !scanner.Scan() {
+			var err = scanner.Err()
+			c.readMutex.Lock()
+			//Клиент не вошёл за loginTimeout
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				c.log().Info("login timeout")
+				c.server.metrics.connsDropped.inc(DropLoginTimeout)
+				c.sendError(ErrLoginTimeout, "no CONNECTION within the login timeout")
+			} else {
+				if err == nil {
+					err = io.EOF
+				}
+				c.log().Info("read failed", "err", err)
+			}
+			c.server.disconnect(c)
 			c.active = false
+			c.readMutex.Unlock()
 			break
 		}
-		source := string(input[0:n])
+		var line = scanner.Text()
+		c.server.metrics.bytesIn.Add(uint64(len(line) + 1))
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		c.readMutex.Lock()
 		var current = c.dataReceivedListeners
 		for {
-			funcPeek(current)(source, c)
+			funcPeek(current)(line, c)
 			if current.next != nil {
 				current = current.next
 			} else {
@@ -51,12 +80,14 @@ func (c *connectedClient) communicate() {
 
 //Отправляет данные клиенту
 func (c *connectedClient) SendData(data []byte) {
-	fmt.Printf("Sending %s to %s\n", data, c.name)
+	c.log().Debug("send", "data", strings.TrimSpace(string(data)))
 	if c.active {
 		w := bufio.NewWriter(c.conn)
-		if _, err := w.Write(data); err != nil {
-			fmt.Println("Write error:", err)
+		if n, err := w.Write(data); err != nil {
+			c.log().Warn("write failed", "err", err)
 			c.active = false
+		} else {
+			c.server.metrics.bytesOut.Add(uint64(n))
 		}
 		_ = w.Flush()
 		//n, err := c.conn.Write(data)
@@ -67,13 +98,37 @@ func (c *connectedClient) SendData(data []byte) {
 	}
 }
 
+//Отвечает клиенту на команду, которая сейчас обрабатывается. Если в команде был REQ_ID, то он добавляется в
+//ответ: в объект - полем REQ_ID, а остальные ответы, например массивы, оборачиваются в {"REQ_ID":..,"DATA":..}.
+//События игры отправляются через SendData и REQ_ID не содержат
+func (c *connectedClient) reply(v any) {
+	data, _ := json.Marshal(v)
+	if c.reqID != nil {
+		data = withRequestID(data, c.reqID)
+	}
+	c.SendData(append(data, '\n'))
+}
+
+//Добавляет REQ_ID id в ответ data
+func withRequestID(data []byte, id json.RawMessage) []byte {
+	if !bytes.HasPrefix(data, []byte("{")) {
+		res, _ := json.Marshal(TaggedResponse{ReqID: id, Data: data})
+		return res
+	}
+	var res = append([]byte(`{"REQ_ID":`), id...)
+	if !bytes.Equal(data, []byte("{}")) {
+		res = append(res, ',')
+	}
+	return append(res, data[1:]...)
+}
+
 //Останавливает общение с клиентом
 func (c *connectedClient) Stop() {
 	//println("Stopping communication")
 	c.active = false
 	err := c.conn.Close()
 	if err != nil {
-		fmt.Println("Closing error:", err)
+		c.log().Warn("close failed", "err", err)
 	}
 }
 
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//Команды, пришедшие одним пакетом, обрабатываются все и по порядку
+func TestPipelinedCommands(t *testing.T) {
+	var h = startHarness(t)
+	var players = loginAll(t, h)
+	var c = players[0]
+	if _, err := c.conn.Write([]byte("GET STATS {\"REQ_ID\":1}\nGET LOBBY {\"REQ_ID\":2}\r\n\nGET STATS {\"REQ_ID\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		line, err := c.Read()
+		if err != nil {
+			t.Fatalf("reply with REQ_ID %s expected: %v", want, err)
+		}
+		var reply TaggedResponse
+		if err = json.Unmarshal([]byte(line), &reply); err != nil || string(reply.ReqID) != want {
+			t.Fatalf("reply with REQ_ID %s expected, got %s", want, line)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import "time"
+
+//Режимы контроля времени
+const (
+	FischerControl   = "fischer"   //После каждого хода к банку добавляется increment
+	BronsteinControl = "bronstein" //После каждого хода возвращается потраченное время, но не больше delay
+)
+
+//Шахматные часы, по одному банку времени на каждого игрока
+type chessClock struct {
+	mode      string          //Режим контроля времени
+	remaining []time.Duration //Оставшееся время игроков в порядке ходов
+	increment time.Duration   //Добавка Фишера
+	delay     time.Duration   //Задержка Бронштейна
+	perMove   time.Duration   //Таймаут хода, если банк времени не задан
+	banked    bool            //Ограничены ли игроки общим банком времени, а не таймаутом хода
+}
+
+//Создаёт часы для лобби на count игроков. Если банк времени не задан, то каждый ход ограничен таймаутом
+//perMove
+func newChessClock(info LobbyInfo, count int, perMove time.Duration) *chessClock {
+	var res = new(chessClock)
+	*res = chessClock{
+		mode:      info.TimeControl,
+		increment: time.Duration(info.Increment) * time.Millisecond,
+		delay:     time.Duration(info.Delay) * time.Millisecond,
+		perMove:   perMove,
+		remaining: make([]time.Duration, count),
+		banked:    info.TimeBank > 0,
+	}
+	for i := range res.remaining {
+		res.remaining[i] = time.Duration(info.TimeBank) * time.Millisecond
+	}
+	return res
+}
+
+//Время, которое есть у игрока side на текущий ход
+func (c *chessClock) budget(side int) time.Duration {
+	if !c.banked {
+		return c.perMove
+	}
+	return c.remaining[side]
+}
+
+//Списывает с банка игрока side время used, потраченное на ход, и начисляет добавку. Возвращает false, если
+//игрок не уложился в своё время
+func (c *chessClock) punch(side int, used time.Duration) bool {
+	if !c.banked {
+		return used < c.perMove
+	}
+	if used >= c.remaining[side] {
+		c.remaining[side] = 0
+		return false
+	}
+	c.remaining[side] -= used
+	switch c.mode {
+	case BronsteinControl:
+		if used < c.delay {
+			c.remaining[side] += used
+		} else {
+			c.remaining[side] += c.delay
+		}
+	default:
+		c.remaining[side] += c.increment
+	}
+	return true
+}
+
+//Оставшееся время игрока side в миллисекундах, для отправки клиентам
+func (c *chessClock) left(side int) uint32 {
+	return uint32(c.budget(side) / time.Millisecond)
+}
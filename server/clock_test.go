@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChessClockPunch(t *testing.T) {
+	var fischer = LobbyInfo{TimeControl: FischerControl, TimeBank: 10000, Increment: 2000}
+	var bronstein = LobbyInfo{TimeControl: BronsteinControl, TimeBank: 10000, Delay: 2000}
+	var tests = []struct {
+		name  string
+		info  LobbyInfo
+		used  time.Duration
+		ok    bool
+		left  uint32 //Оставшееся время игрока в миллисекундах после хода
+		other uint32 //Время второго игрока, которое не должно измениться
+	}{
+		{"move timeout in time", LobbyInfo{}, 4 * time.Second, true, 5000, 5000},
+		{"move timeout exceeded", LobbyInfo{}, 5 * time.Second, false, 5000, 5000},
+		{"fischer adds the increment", fischer, 3 * time.Second, true, 9000, 10000},
+		{"fischer quick move gains time", fischer, time.Second, true, 11000, 10000},
+		{"fischer bank exceeded", fischer, 10 * time.Second, false, 0, 10000},
+		{"bronstein returns used time within the delay", bronstein, time.Second, true, 10000, 10000},
+		{"bronstein returns at most the delay", bronstein, 3 * time.Second, true, 9000, 10000},
+		{"bronstein bank exceeded", bronstein, 11 * time.Second, false, 0, 10000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var clock = newChessClock(test.info, 2, 5*time.Second)
+			if ok := clock.punch(0, test.used); ok != test.ok {
+				t.Errorf("punch(%v) = %v, want %v", test.used, ok, test.ok)
+			}
+			if left := clock.left(0); left != test.left {
+				t.Errorf("%d ms left, want %d", left, test.left)
+			}
+			if other := clock.left(1); other != test.other {
+				t.Errorf("opponent has %d ms left, want %d", other, test.other)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goServer/board"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//Файл настроек по умолчанию
+const DefaultConfigPath = "resources/config.json"
+
+//Префикс переменных окружения, которые переопределяют настройки из файла. Имя переменной получается из
+//json-имени настройки: dbPassword - GOSERVER_DB_PASSWORD, max_turns - GOSERVER_MAX_TURNS,
+//maxConnectionsPerIP - GOSERVER_MAX_CONNECTIONS_PER_IP
+const EnvPrefix = "GOSERVER_"
+
+//Читает настройки: сначала файл, путь к которому задаётся флагом -config, затем переменные окружения. Если
+//loginFromFile выключен, то логин и пароль БД, не заданные в окружении, спрашиваются в консоли. Возвращает
+//настройки и путь к файлу настроек, а при ошибках - их все
+func readConfigs() (configs, string, error) {
+	var flags = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	var path = flags.String("config", DefaultConfigPath, "path to the JSON config file")
+	_ = flags.Parse(os.Args[1:])
+	var res, err = loadConfigs(*path)
+	if err == nil && !res.LoginFromFile {
+		err = askCredentials(&res)
+	}
+	if err == nil {
+		err = res.validate()
+	}
+	if err != nil {
+		return res, *path, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return res, *path, nil
+}
+
+//Путь к спецификации протокола. Относительный путь отсчитывается от каталога файла настроек configPath, а не
+//от рабочего каталога, поэтому сервер можно запускать из любого каталога
+func (c configs) protocolPath(configPath string) string {
+	var path = c.ProtocolPath
+	if path == "" {
+		path = DefaultProtocolPath
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
+
+//Читает файл настроек path и переопределяет настройки переменными окружения
+func loadConfigs(path string) (configs, error) {
+	var res configs
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return res, err
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("%s: %w", path, err)
+	}
+	return res, res.applyEnv(os.LookupEnv)
+}
+
+//Имя настройки в файле настроек
+func settingName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+//Имя переменной окружения для настройки с json-именем name
+func envName(name string) string {
+	var str strings.Builder
+	str.WriteString(EnvPrefix)
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 && !unicode.IsUpper(rune(name[i-1])) {
+			str.WriteRune('_')
+		}
+		str.WriteRune(unicode.ToUpper(r))
+	}
+	return str.String()
+}
+
+//Переопределяет настройки значениями переменных окружения, которые возвращает lookup
+func (c *configs) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	var value = reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		var field = value.Type().Field(i)
+		var name = envName(settingName(field))
+		var str, ok = lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(value.Field(i), str); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//Записывает в поле настроек значение из строки str
+func setFromString(field reflect.Value, str string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(str)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", str)
+		}
+		field.SetBool(val)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := strconv.ParseInt(str, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer of %d bits", str, field.Type().Bits())
+		}
+		field.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(str, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a non-negative integer of %d bits", str, field.Type().Bits())
+		}
+		field.SetUint(val)
+	case reflect.Map:
+		if err := json.Unmarshal([]byte(str), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("%q is not a JSON object: %w", str, err)
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+//Спрашивает в консоли логин и пароль БД, если они не заданы переменными окружения
+func askCredentials(c *configs) error {
+	var scanner = bufio.NewScanner(os.Stdin)
+	for _, val := range []struct {
+		name  string
+		value *string
+	}{{"dbLogin", &c.DbLogin}, {"dbPassword", &c.DbPassword}} {
+		if _, ok := os.LookupEnv(envName(val.name)); ok {
+			continue
+		}
+		fmt.Printf("%s: ", val.name)
+		if !scanner.Scan() {
+			return errors.New("can't read " + val.name + " from the console")
+		}
+		*val.value = strings.TrimSpace(scanner.Text())
+	}
+	return nil
+}
+
+//Проверяет все настройки и возвращает все найденные ошибки сразу. Каждая ошибка называет настройку и переменную
+//окружения, которой её можно переопределить
+func (c configs) validate() error {
+	var errs []error
+	var check = func(ok bool, name, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s (%s) %s", name, envName(name), fmt.Sprintf(format, args...)))
+		}
+	}
+	check(c.ServerPort > 0 && c.ServerPort <= 65535, "serverPort", "must be from 1 to 65535, got %d", c.ServerPort)
+	check(c.MariaPort <= 65535, "mariaPort", "must be at most 65535, got %d", c.MariaPort)
+	check(c.DbName != "", "dbName", "must not be empty")
+	check(c.DbLogin != "", "dbLogin", "must not be empty")
+	check(c.GamesToPlay > 0, "gamesToPlay", "must be positive")
+	check(c.Timeout > 0, "timeout", "must be positive")
+	check(c.MaxTurns > 0, "max_turns", "must be positive, got %d", c.MaxTurns)
+	check(c.RepetitionLimit >= 0, "repetitionLimit", "must not be negative, got %d", c.RepetitionLimit)
+	check(c.NoProgressTurns >= 0, "noProgressTurns", "must not be negative, got %d", c.NoProgressTurns)
+	check(oneOf(c.TimeControl, "", FischerControl, BronsteinControl),
+		"timeControl", "must be %s or %s, got %q", FischerControl, BronsteinControl, c.TimeControl)
+	check(oneOf(c.Generator, "", RandomGenerator, BalancedGenerator, SymmetricGenerator),
+		"generator", "must be %s, %s or %s, got %q", RandomGenerator, BalancedGenerator, SymmetricGenerator, c.Generator)
+	//Разница кратчайших путей больше стороны поля пропускает почти любое поле
+	check(c.FairnessTolerance <= MaxFieldSize, "fairnessTolerance", "must be at most %d, got %d", MaxFieldSize, c.FairnessTolerance)
+	_, known := board.Variants[c.Variant]
+	check(c.Variant == "" || known, "variant", "must be a known variant, got %q", c.Variant)
+	check(c.Visibility <= MaxFieldSize, "visibility", "must be at most %d, got %d", MaxFieldSize, c.Visibility)
+	var level slog.Level
+	check(c.LogLevel == "" || level.UnmarshalText([]byte(c.LogLevel)) == nil,
+		"logLevel", "must be debug, info, warn or error, got %q", c.LogLevel)
+	check(oneOf(strings.ToLower(c.LogFormat), "", TextLogFormat, JSONLogFormat),
+		"logFormat", "must be %s or %s, got %q", TextLogFormat, JSONLogFormat, c.LogFormat)
+	check(c.MetricsAddress == "" || isHostPort(c.MetricsAddress),
+		"metricsAddress", "must be host:port or :port, got %q", c.MetricsAddress)
+	check(c.ShutdownTimeout > 0, "shutdownTimeout", "must be positive")
+	check(c.LoginTimeout > 0, "loginTimeout", "must be positive")
+	for class, limit := range c.RateLimits {
+		_, known := commandClasses[class]
+		check(known, "rateLimits", "has unknown command class %q", class)
+		check(limit.Rate > 0 && limit.Burst > 0, "rateLimits", "%s: rate and burst must be positive", class)
+	}
+	return errors.Join(errs...)
+}
+
+//Проверяет, что адрес address имеет вид host:port или :port
+func isHostPort(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && number > 0
+}
+
+//Проверяет, совпадает ли str с одним из значений values
+func oneOf(str string, values ...string) bool {
+	for _, val := range values {
+		if str == val {
+			return true
+		}
+	}
+	return false
+}
+
+//Настройки в виде JSON для вывода в журнал. Пароль БД скрывается
+func (c configs) String() string {
+	if c.DbPassword != "" {
+		c.DbPassword = "***"
+	}
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+//Адрес БД для драйвера MySQL. Если адрес MariaDB не задан, то драйвер подключается к localhost:3306
+func (c configs) dataSource() string {
+	var address string
+	if c.MariaAddress != "" {
+		var port = c.MariaPort
+		if port == 0 {
+			port = 3306
+		}
+		address = fmt.Sprintf("tcp(%s:%d)", c.MariaAddress, port)
+	}
+	return fmt.Sprintf("%s:%s@%s/%s", c.DbLogin, c.DbPassword, address, c.DbName)
+}
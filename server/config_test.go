@@ -0,0 +1,151 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	var tests = map[string]string{
+		"dbPassword":          "GOSERVER_DB_PASSWORD",
+		"max_turns":           "GOSERVER_MAX_TURNS",
+		"maxConnectionsPerIP": "GOSERVER_MAX_CONNECTIONS_PER_IP",
+		"seed":                "GOSERVER_SEED",
+	}
+	for name, want := range tests {
+		if env := envName(name); env != want {
+			t.Errorf("envName(%q) = %q, want %q", name, env, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	var tests = []struct {
+		name  string
+		env   map[string]string
+		check func(c configs) bool
+		err   string //Часть сообщения об ошибке, пустая, если переменные подходят
+	}{
+		{"string", map[string]string{"GOSERVER_VARIANT": "fog"}, func(c configs) bool { return c.Variant == "fog" }, ""},
+		{"unsigned", map[string]string{"GOSERVER_SERVER_PORT": "6000"}, func(c configs) bool { return c.ServerPort == 6000 }, ""},
+		{"signed", map[string]string{"GOSERVER_SEED": "-5"}, func(c configs) bool { return c.Seed == -5 }, ""},
+		{"boolean", map[string]string{"GOSERVER_JUMPS": "true"}, func(c configs) bool { return c.Jumps }, ""},
+		{"map", map[string]string{"GOSERVER_RATE_LIMITS": `{"query":{"rate":1,"burst":2}}`},
+			func(c configs) bool { return c.RateLimits[QueryCommands] == rateLimit{Rate: 1, Burst: 2} }, ""},
+		{"unset keeps the file value", map[string]string{}, func(c configs) bool { return c.MaxTurns == 30 }, ""},
+		{"not a number", map[string]string{"GOSERVER_MAX_TURNS": "many"}, nil, "GOSERVER_MAX_TURNS"},
+		{"negative port", map[string]string{"GOSERVER_SERVER_PORT": "-1"}, nil, "GOSERVER_SERVER_PORT"},
+		{"too big", map[string]string{"GOSERVER_VISIBILITY": "300"}, nil, "GOSERVER_VISIBILITY"},
+		{"not a boolean", map[string]string{"GOSERVER_JUMPS": "sometimes"}, nil, "GOSERVER_JUMPS"},
+		{"not an object", map[string]string{"GOSERVER_RATE_LIMITS": "fast"}, nil, "GOSERVER_RATE_LIMITS"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var conf = configs{MaxTurns: 30}
+			var err = conf.applyEnv(func(name string) (string, bool) {
+				val, ok := test.env[name]
+				return val, ok
+			})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("applyEnv = %v, want an error with %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyEnv = %v", err)
+			}
+			if !test.check(conf) {
+				t.Errorf("setting wasn't applied: %+v", conf)
+			}
+		})
+	}
+}
+
+//Настройки, которые проходят проверку
+func validConfigs() configs {
+	return configs{
+		ServerPort:      5703,
+		DbName:          "competition",
+		DbLogin:         "user",
+		GamesToPlay:     1,
+		Timeout:         5,
+		MaxTurns:        30,
+		ShutdownTimeout: 60,
+		LoginTimeout:    30,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		change func(c *configs)
+		err    string //Часть сообщения об ошибке, пустая, если настройки верны
+	}{
+		{"valid", func(c *configs) {}, ""},
+		{"all options", func(c *configs) {
+			c.TimeControl, c.Generator, c.Variant, c.Visibility = BronsteinControl, BalancedGenerator, "fog", 3
+			c.LogLevel, c.LogFormat, c.MetricsAddress = "debug", "JSON", "localhost:9100"
+			c.RateLimits = map[string]rateLimit{OtherCommands: {Rate: 0.5, Burst: 1}}
+		}, ""},
+		{"metrics on all interfaces", func(c *configs) { c.MetricsAddress = ":9100" }, ""},
+		{"server port", func(c *configs) { c.ServerPort = 0 }, "serverPort (GOSERVER_SERVER_PORT)"},
+		{"maria port", func(c *configs) { c.MariaPort = 70000 }, "mariaPort (GOSERVER_MARIA_PORT)"},
+		{"database name", func(c *configs) { c.DbName = "" }, "dbName (GOSERVER_DB_NAME)"},
+		{"database login", func(c *configs) { c.DbLogin = "" }, "dbLogin (GOSERVER_DB_LOGIN)"},
+		{"games to play", func(c *configs) { c.GamesToPlay = 0 }, "gamesToPlay (GOSERVER_GAMES_TO_PLAY)"},
+		{"timeout", func(c *configs) { c.Timeout = 0 }, "timeout (GOSERVER_TIMEOUT)"},
+		{"max turns", func(c *configs) { c.MaxTurns = 0 }, "max_turns (GOSERVER_MAX_TURNS)"},
+		{"repetition limit", func(c *configs) { c.RepetitionLimit = -1 }, "repetitionLimit (GOSERVER_REPETITION_LIMIT)"},
+		{"no progress turns", func(c *configs) { c.NoProgressTurns = -1 }, "noProgressTurns (GOSERVER_NO_PROGRESS_TURNS)"},
+		{"time control", func(c *configs) { c.TimeControl = "blitz" }, "timeControl (GOSERVER_TIME_CONTROL)"},
+		{"generator", func(c *configs) { c.Generator = "maze" }, "generator (GOSERVER_GENERATOR)"},
+		{"fairness tolerance", func(c *configs) { c.FairnessTolerance = MaxFieldSize + 1 }, "fairnessTolerance (GOSERVER_FAIRNESS_TOLERANCE)"},
+		{"variant", func(c *configs) { c.Variant = "chess" }, "variant (GOSERVER_VARIANT)"},
+		{"visibility", func(c *configs) { c.Visibility = MaxFieldSize + 1 }, "visibility (GOSERVER_VISIBILITY)"},
+		{"log level", func(c *configs) { c.LogLevel = "loud" }, "logLevel (GOSERVER_LOG_LEVEL)"},
+		{"log format", func(c *configs) { c.LogFormat = "xml" }, "logFormat (GOSERVER_LOG_FORMAT)"},
+		{"metrics without a port", func(c *configs) { c.MetricsAddress = "localhost" }, "metricsAddress (GOSERVER_METRICS_ADDRESS)"},
+		{"metrics with a wrong port", func(c *configs) { c.MetricsAddress = ":http" }, "metricsAddress (GOSERVER_METRICS_ADDRESS)"},
+		{"shutdown timeout", func(c *configs) { c.ShutdownTimeout = 0 }, "shutdownTimeout (GOSERVER_SHUTDOWN_TIMEOUT)"},
+		{"login timeout", func(c *configs) { c.LoginTimeout = 0 }, "loginTimeout (GOSERVER_LOGIN_TIMEOUT)"},
+		{"rate limit class", func(c *configs) { c.RateLimits = map[string]rateLimit{"chat": {Rate: 1, Burst: 1}} },
+			`rateLimits (GOSERVER_RATE_LIMITS) has unknown command class "chat"`},
+		{"rate limit values", func(c *configs) { c.RateLimits = map[string]rateLimit{QueryCommands: {Rate: 1}} },
+			"rateLimits (GOSERVER_RATE_LIMITS) query: rate and burst must be positive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var conf = validConfigs()
+			test.change(&conf)
+			var err = conf.validate()
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("validate = %v, want no error", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("validate = %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+}
+
+//Все ошибки возвращаются сразу
+func TestValidateAllErrors(t *testing.T) {
+	var conf = validConfigs()
+	conf.ServerPort, conf.GamesToPlay, conf.LoginTimeout = 0, 0, 0
+	var err = conf.validate()
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("validate = %v, want three errors", err)
+	}
+}
+
+//Файл настроек из репозитория проходит проверку
+func TestDefaultConfig(t *testing.T) {
+	conf, err := loadConfigs("../" + DefaultConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conf.validate(); err != nil {
+		t.Error(err)
+	}
+}
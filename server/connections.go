@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+//Причины, по которым клиенту отказано в подключении или он отключён принудительно
+const (
+	DropMaxConnections = "max_connections"        //Подключено наибольшее количество клиентов
+	DropMaxPerIP       = "max_connections_per_ip" //С этого адреса подключено наибольшее количество клиентов
+	DropLoginTimeout   = "login_timeout"          //Клиент не вошёл за loginTimeout
+	DropRateLimit      = "rate_limit"             //Клиент слишком часто превышал ограничения частоты запросов
+)
+
+//Проверяет, можно ли принять подключение conn. Возвращает причину отказа или пустую строку
+func (s *server) admit(conn net.Conn) string {
+	s.configsMutex.Lock()
+	var total, perIP = s.Configs.MaxConnections, s.Configs.MaxConnectionsPerIP
+	s.configsMutex.Unlock()
+	if total == 0 {
+		total = MaxPlayers
+	}
+	var host = clientHost(conn.RemoteAddr())
+	s.clientsMapMutex.Lock()
+	defer s.clientsMapMutex.Unlock()
+	if uint(len(s.connectedClient)) >= total {
+		return DropMaxConnections
+	}
+	if perIP > 0 {
+		var count uint
+		for c := range s.connectedClient {
+			if clientHost(c.conn.RemoteAddr()) == host {
+				count += 1
+			}
+		}
+		if count >= perIP {
+			return DropMaxPerIP
+		}
+	}
+	return ""
+}
+
+//Отправляет клиенту отказ в подключении с причиной reason и закрывает соединение
+func (s *server) reject(conn net.Conn, reason string) {
+	s.logger.Warn("connection refused", "addr", conn.RemoteAddr().String(), "reason", reason)
+	s.metrics.connsDropped.inc(reason)
+	var message = "too many connections"
+	if reason == DropMaxPerIP {
+		message = "too many connections from this address"
+	}
+	data, _ := json.Marshal(ErrorResponse{Error: ErrorInfo{Code: ErrTooManyConnections, Message: message}})
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Write([]byte(fmt.Sprintf("%s\n", string(data))))
+	_ = conn.Close()
+}
+
+//Сколько ждать входа клиента после подключения. 0 - не ограничено
+func (s *server) loginTimeout() time.Duration {
+	s.configsMutex.Lock()
+	defer s.configsMutex.Unlock()
+	return time.Duration(s.Configs.LoginTimeout) * time.Second
+}
+
+//IP-адрес клиента без порта
+func clientHost(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"goServer/schema"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+//Зависимости сервера. В NewServer это TCP-порт serverPort, MariaDB, системные часы, случайные числа от текущего
+//времени, журнал по настройкам и спецификация протокола из файла, а в проверках их можно подменить
+type Deps struct {
+	Listener     net.Listener             //Откуда принимаются подключения
+	Store        Store                    //Где хранятся участники, лобби и результаты
+	Clock        Clock                    //Часы для времени ходов и задержек. Если nil, то системные
+	Rand         rand.Source              //Случайные числа для лобби и порядка ходов. Если nil, то от текущего времени
+	Participants func() ([]string, error) //Список участников. Если nil, то читается из ParticipantsPath
+	Logger       *slog.Logger             //Журнал. Если nil, то журнал не пишется
+	LogLevel     *slog.LevelVar           //Уровень журнала Logger, который меняет перезагрузка настроек. Может быть nil
+	Protocol     *schema.Spec             //Спецификация протокола, по которой проверяются данные команд и ходы
+}
+
+//Часы сервера
+type Clock interface {
+	Now() time.Time
+	//Таймер, который сработает через d
+	NewTimer(d time.Duration) Timer
+}
+
+//Таймер часов Clock
+type Timer interface {
+	//Канал, в который таймер пишет время срабатывания
+	C() <-chan time.Time
+	//Останавливает таймер. Возвращает false, если он уже сработал или был остановлен
+	Stop() bool
+}
+
+//Системные часы
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+//Таймер системных часов
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+//Ждёт d по часам clock
+func sleep(clock Clock, d time.Duration) {
+	<-clock.NewTimer(d).C()
+}
+
+//Источник случайных чисел, которым можно пользоваться из нескольких горутин
+type lockedSource struct {
+	mutex  sync.Mutex
+	source rand.Source
+}
+
+func (l *lockedSource) Int63() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.source.Int63()
+}
+
+func (l *lockedSource) Seed(seed int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.source.Seed(seed)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/binary"
+	"goServer/board"
+	"hash/fnv"
+)
+
+//Следит за позициями игры, чтобы объявить ничью при повторении позиции или отсутствии продвижения
+type drawTracker struct {
+	seen         map[uint64]int //Сколько раз встречалась позиция с данным хешем
+	distances    []int          //Кратчайшие пути игроков после последнего хода
+	lastProgress int            //Номер хода, на котором кратчайшие пути в последний раз изменились
+	rules        board.Variant  //Правила, по которым считаются кратчайшие пути
+	limits       gameLimits     //Ограничения игры: сколько повторений и ходов без продвижения ведут к ничьей
+}
+
+//Создаёт наблюдателя за позицией field перед ходом turn, в которой ходит игрок next. Продолженная отложенная
+//игра начинает считать повторения и ходы без продвижения заново
+func newDrawTracker(field Field, next int, turn int, rules board.Variant, limits gameLimits) *drawTracker {
+	var res = new(drawTracker)
+	*res = drawTracker{
+		seen:         map[uint64]int{},
+		lastProgress: turn - 1,
+		rules:        rules,
+		limits:       limits,
+	}
+	res.seen[positionHash(field, next)] = 1
+	if limits.noProgressTurns > 0 {
+		res.distances = fieldDistances(field, rules)
+	}
+	return res
+}
+
+//Учитывает позицию field после хода turn, в которой ходит игрок next. Возвращает причину ничьей или пустую
+//строку, если игру можно продолжать
+func (t *drawTracker) update(field Field, next int, turn int) string {
+	var hash = positionHash(field, next)
+	t.seen[hash] += 1
+	if t.limits.repetitionLimit > 0 && t.seen[hash] >= t.limits.repetitionLimit {
+		return ReasonRepetition
+	}
+	if t.limits.noProgressTurns > 0 {
+		var distances = fieldDistances(field, t.rules)
+		for i, val := range distances {
+			if val != t.distances[i] {
+				t.lastProgress = turn
+				break
+			}
+		}
+		t.distances = distances
+		if turn-t.lastProgress >= t.limits.noProgressTurns {
+			return ReasonNoProgress
+		}
+	}
+	return ""
+}
+
+//Хеш позиции: очередь хода, позиции игроков и все препятствия. Препятствия только добавляются, поэтому
+//одинаковые позиции всегда имеют препятствия в одном и том же порядке
+func positionHash(field Field, next int) uint64 {
+	var h = fnv.New64a()
+	var buf [8]byte
+	var write = func(val int) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(val))
+		_, _ = h.Write(buf[:])
+	}
+	write(next)
+	for _, val := range field.Positions {
+		write(val[0])
+		write(val[1])
+	}
+	for _, barrier := range field.Barriers {
+		for _, cell := range barrier {
+			write(cell[0])
+			write(cell[1])
+		}
+	}
+	return h.Sum64()
+}
+
+//Проверяет, закончилась ли игра с такой причиной ничьей
+func isDraw(reason string) bool {
+	return reason == ReasonMaxTurns || reason == ReasonRepetition || reason == ReasonNoProgress
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"goServer/board"
+	"testing"
+)
+
+func TestDrawTrackerUpdate(t *testing.T) {
+	//Игроки ходят туда и обратно: после четырёх ходов позиция повторяется
+	var backAndForth = [][][2]int{
+		{{1, 2}, {4, 2}}, {{1, 2}, {3, 2}}, {{0, 2}, {3, 2}}, {{0, 2}, {4, 2}},
+		{{1, 2}, {4, 2}}, {{1, 2}, {3, 2}}, {{0, 2}, {3, 2}}, {{0, 2}, {4, 2}},
+	}
+	//Игроки ходят вбок, кратчайшие пути не меняются
+	var sideways = [][][2]int{{{0, 3}, {4, 2}}, {{0, 3}, {4, 3}}, {{0, 2}, {4, 3}}, {{0, 2}, {4, 2}}}
+	//Второй ход продвигает игрока, и счёт ходов без продвижения начинается заново
+	var progress = [][][2]int{
+		{{0, 3}, {4, 2}}, {{0, 3}, {3, 2}}, {{0, 2}, {3, 2}}, {{0, 2}, {3, 3}}, {{0, 3}, {3, 3}}, {{0, 3}, {3, 2}},
+	}
+	var tests = []struct {
+		name   string
+		limits gameLimits
+		steps  [][][2]int //Позиции игроков после каждого хода
+		want   string     //Причина ничьей после последнего хода. После остальных ходов игра продолжается
+	}{
+		{"third repetition", gameLimits{repetitionLimit: 3}, backAndForth, ReasonRepetition},
+		{"second repetition", gameLimits{repetitionLimit: 3}, backAndForth[:4], ""},
+		{"repetitions allowed", gameLimits{}, backAndForth, ""},
+		{"no progress", gameLimits{noProgressTurns: 4}, sideways, ReasonNoProgress},
+		{"progress resets the count", gameLimits{noProgressTurns: 4}, progress, ReasonNoProgress},
+		{"progress in time", gameLimits{noProgressTurns: 4}, progress[:5], ""},
+		{"no progress allowed", gameLimits{}, sideways, ""},
+	}
+	var rules = board.Rules(board.ClassicVariant, false)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var field = Field{Width: 5, Height: 5, Positions: [][2]int{{0, 2}, {4, 2}}}
+			var tracker = newDrawTracker(field, 0, 1, rules, test.limits)
+			for i, positions := range test.steps {
+				var turn = i + 1
+				field.Positions = positions
+				var reason = tracker.update(field, turn%2, turn)
+				var want = ""
+				if i == len(test.steps)-1 {
+					want = test.want
+				}
+				if reason != want {
+					t.Fatalf("update after turn %d = %q, want %q", turn, reason, want)
+				}
+			}
+		})
+	}
+}
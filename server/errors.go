@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+//Коды ошибок протокола. Коды не меняются между версиями сервера, по ним клиенты могут реагировать на ошибки.
+//Ошибка приходит в виде {"ERROR":{"code":"...","message":"..."}}, message - пояснение для человека
+const (
+	ErrBadRequest         = "BAD_REQUEST"          //Нет данных, которые требует команда, в них неверный JSON или REQ_ID
+	ErrUnknownCommand     = "UNKNOWN_COMMAND"      //Неизвестная команда
+	ErrNotPlaying         = "NOT_PLAYING"          //Ход прислан клиентом, который сейчас не играет
+	ErrHiddenConflict     = "HIDDEN_CONFLICT"      //В тумане войны ход противоречит тому, чего игрок не видит, нужно сходить ещё раз
+	ErrLoginRequired      = "LOGIN_REQUIRED"       //Команда доступна только после CONNECTION
+	ErrLoginFailed        = "LOGIN_FAILED"         //Логина нет в списке участников
+	ErrLoginTimeout       = "LOGIN_TIMEOUT"        //Клиент не вошёл за loginTimeout, соединение закрывается
+	ErrTooManyConnections = "TOO_MANY_CONNECTIONS" //Превышено ограничение подключений, соединение закрывается
+	ErrThrottled          = "THROTTLED"            //Превышено ограничение частоты запросов, retryAfter - через сколько мс повторить
+	ErrInvalidLobby       = "INVALID_LOBBY"        //Параметры создаваемого лобби неверны
+	ErrInvalidField       = "INVALID_FIELD"        //Параметры поля в GET FIELD неверны
+	ErrLobbyNotFound      = "LOBBY_NOT_FOUND"      //Лобби с таким id нет
+	ErrNoScheduledGame    = "NO_SCHEDULED_GAME"    //У игрока не осталось игр по расписанию
+	ErrLobbyBusy          = "LOBBY_BUSY"           //В лобби уже идёт игра
+	ErrNotInTeam          = "NOT_IN_TEAM"          //Игрок не входит ни в одну из команд лобби
+	ErrNotInLobby         = "NOT_IN_LOBBY"         //Клиент не ждёт игры ни в каком лобби
+	ErrShuttingDown       = "SHUTTING_DOWN"        //Сервер останавливается и не начинает новых игр
+	ErrInternal           = "INTERNAL_ERROR"       //Ошибка БД или самого сервера
+)
+
+//Ошибка с кодом протокола, которую можно отправить клиенту
+type protocolError struct {
+	code    string
+	message string
+}
+
+func (e *protocolError) Error() string {
+	return e.message
+}
+
+//Создаёт ошибку с кодом протокола code
+func newError(code, message string) error {
+	return &protocolError{code: code, message: message}
+}
+
+//Код протокола для ошибки err. Ошибки разбора JSON - это BAD_REQUEST, ошибки без кода - INTERNAL_ERROR
+func errorCode(err error) string {
+	var protocol *protocolError
+	var syntax *json.SyntaxError
+	var unmarshal *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &protocol):
+		return protocol.code
+	case errors.As(err, &syntax), errors.As(err, &unmarshal):
+		return ErrBadRequest
+	default:
+		return ErrInternal
+	}
+}
+
+//Отправляет клиенту ошибку с кодом code
+func (c *connectedClient) sendError(code, message string) {
+	c.sendErrorInfo(ErrorInfo{Code: code, Message: message})
+}
+
+//Отправляет клиенту ошибку err с подходящим ей кодом. Подробности внутренних ошибок клиенту не сообщаются
+func (c *connectedClient) reportError(err error) {
+	var code = errorCode(err)
+	var message = err.Error()
+	if code == ErrInternal {
+		message = "internal server error"
+	}
+	c.sendError(code, message)
+}
+
+func (c *connectedClient) sendErrorInfo(info ErrorInfo) {
+	c.reply(ErrorResponse{Error: info})
+}
+
+//Отправляет клиенту ошибку из горутины игры. REQ_ID команды, которую сейчас обрабатывает горутина чтения, в неё
+//не добавляется, как и в другие события игры
+func (c *connectedClient) sendGameError(code, message string) {
+	data, _ := json.Marshal(ErrorResponse{Error: ErrorInfo{Code: code, Message: message}})
+	c.SendData(append(data, '\n'))
+}
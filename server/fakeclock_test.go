@@ -0,0 +1,95 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+//Часы, время которых идёт только по Advance. С ними игры не зависят от того, как быстро отвечают клиенты:
+//время обдумывания всегда равно тому, на сколько часы передвинули, а таймауты срабатывают только по Advance
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer //Ожидающие таймеры
+}
+
+//Таймер часов fakeClock
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+//Создаёт часы, показывающие время now
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+//Таймер на неположительное время срабатывает сразу
+func (f *fakeClock) NewTimer(d time.Duration) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var res = &fakeTimer{clock: f, at: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		res.c <- f.now
+	} else {
+		f.timers = append(f.timers, res)
+	}
+	return res
+}
+
+//Передвигает часы на d и срабатывает таймеры, время которых пришло
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	var pending = f.timers[:0]
+	for _, val := range f.timers {
+		if val.at.After(f.now) {
+			pending = append(pending, val)
+		} else {
+			val.c <- f.now
+		}
+	}
+	f.timers = pending
+}
+
+//Ждёт, пока у часов будет хотя бы n ожидающих таймеров, но не дольше timeout настоящего времени. Возвращает
+//false, если не дождался. Нужно, чтобы передвигать часы только после того, как сервер начал чего-то ждать
+func (f *fakeClock) WaitTimers(n int, timeout time.Duration) bool {
+	var deadline = time.Now().Add(timeout)
+	for {
+		f.mutex.Lock()
+		var count = len(f.timers)
+		f.mutex.Unlock()
+		if count >= n {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, val := range t.clock.timers {
+		if val == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package server
+
+import "goServer/board"
+
+//Сколько сгенерированных полей хранит сервер. Когда их становится больше, кэш очищается
+const maxCachedFields = 1024
+
+//Параметры лобби, от которых зависит сгенерированное поле
+type fieldKey struct {
+	width     uint16
+	height    uint16
+	barriers  uint16
+	players   uint8
+	seed      int64
+	generator string
+	tolerance uint8
+	rules     board.Variant
+}
+
+//Поле лобби info. Генерация большого поля занимает секунды, а одно и то же поле нужно, когда лобби сохраняется,
+//когда в него заходят игроки и когда его запрашивают через GET FIELD, поэтому сгенерированные поля запоминаются
+func (s *server) field(info LobbyInfo) (Field, error) {
+	var key = fieldKey{
+		width:     info.Width,
+		height:    info.Height,
+		barriers:  info.GameBarrierCount,
+		players:   info.PlayersCount,
+		seed:      info.Seed,
+		generator: info.Generator,
+		tolerance: info.Tolerance,
+		rules:     info.rules(),
+	}
+	s.fieldsMutex.Lock()
+	field, ok := s.fields[key]
+	s.fieldsMutex.Unlock()
+	if ok {
+		return field.clone(), nil
+	}
+	field, err := generateField(info)
+	if err != nil {
+		return Field{}, err
+	}
+	s.fieldsMutex.Lock()
+	if len(s.fields) >= maxCachedFields {
+		s.fields = make(map[fieldKey]Field)
+	}
+	s.fields[key] = field
+	s.fieldsMutex.Unlock()
+	return field.clone(), nil
+}
+
+//Копия поля, которую можно менять, не меняя кэш
+func (f Field) clone() Field {
+	f.Positions = append([][2]int(nil), f.Positions...)
+	f.Barriers = append([][][2]int(nil), f.Barriers...)
+	return f
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goServer/client"
+	"goServer/schema"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Сервер для проверок в одном процессе. Слушает случайный порт на 127.0.0.1, хранит данные в memoryStore, время
+//идёт только по Clock.Advance, а случайные числа берутся из зерна, поэтому одинаковые сценарии дают одинаковые
+//игры. Клиенты подключаются к нему по настоящему TCP, как и к обычному серверу
+type harness struct {
+	Clock  *fakeClock
+	Store  *memoryStore
+	Addr   string //Адрес, на котором слушает сервер
+	server *server
+	wait   time.Duration //Сколько настоящего времени клиенты ждут ответа
+	done   chan struct{} //Закрывается, когда сервер остановился
+}
+
+//Настройки harness. Нулевые значения заменяются значениями по умолчанию
+type harnessOptions struct {
+	Participants []string      //Логины участников. Для каждой пары создаются лобби по расписанию
+	Seed         int64         //Зерно случайных чисел для лобби и порядка ходов
+	Timeout      time.Duration //Время на ход по часам Clock в целых секундах, по умолчанию 5 секунд
+	MaxTurns     int           //Наибольшее количество ходов, по умолчанию 200
+	GamesToPlay  uint          //Количество игр каждой пары участников, по умолчанию 1
+	ProtocolPath string        //Спецификация протокола, по умолчанию ../resources/protocol.schema.json
+	Log          io.Writer     //Куда писать журнал сервера. Если nil, то журнал не пишется
+	ReadTimeout  time.Duration //Сколько настоящего времени клиенты ждут ответа, по умолчанию 5 секунд
+}
+
+//Начальное время часов harness
+var harnessEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+//Запускает сервер с настройками opts
+func newHarness(opts harnessOptions) (*harness, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxTurns == 0 {
+		opts.MaxTurns = 200
+	}
+	if opts.GamesToPlay == 0 {
+		opts.GamesToPlay = 1
+	}
+	if opts.ProtocolPath == "" {
+		opts.ProtocolPath = filepath.Join("..", "resources", DefaultProtocolPath)
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = 5 * time.Second
+	}
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	spec, err := schema.Load(opts.ProtocolPath)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	var conf = configs{
+		GamesToPlay: opts.GamesToPlay,
+		Timeout:     opts.Timeout / time.Second,
+		MaxTurns:    opts.MaxTurns,
+		Seed:        opts.Seed,
+	}
+	var participants = append([]string{}, opts.Participants...)
+	var res = &harness{
+		Clock: newFakeClock(harnessEpoch),
+		Store: newMemoryStore(),
+		Addr:  listener.Addr().String(),
+		wait:  opts.ReadTimeout,
+		done:  make(chan struct{}),
+	}
+	res.server = newServer(conf, Deps{
+		Listener: listener,
+		Store:    res.Store,
+		Clock:    res.Clock,
+		Rand:     rand.NewSource(opts.Seed),
+		Participants: func() ([]string, error) {
+			return participants, nil
+		},
+		Logger:   slog.New(slog.NewTextHandler(opts.Log, nil)),
+		Protocol: spec,
+	})
+	go func() {
+		defer close(res.done)
+		res.server.Serve()
+	}()
+	return res, nil
+}
+
+//Останавливает сервер и ждёт, пока он закончит работу. Идущие игры откладываются
+func (h *harness) Close() {
+	h.server.stop()
+	<-h.done
+}
+
+//Ждёт, пока закончатся все игры и их результаты будут сохранены. Игроки получают SOCKET ENDGAME раньше, чем
+//результаты попадают в хранилище
+func (h *harness) WaitGames() {
+	h.server.games.Wait()
+}
+
+//Клиент, которым сценарий проверки управляет вручную
+type fakeClient struct {
+	Name    string
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+//Подключает нового клиента без входа
+func (h *harness) Connect() (*fakeClient, error) {
+	conn, err := net.Dial("tcp4", h.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeClient{conn: conn, reader: bufio.NewReader(conn), timeout: h.wait}, nil
+}
+
+//Подключает клиента и входит под логином name
+func (h *harness) Login(name string) (*fakeClient, error) {
+	c, err := h.Connect()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.Command("CONNECTION", fmt.Sprintf(`{"LOGIN":%q}`, name))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if reply != `{"MESSAGE":"LOGIN OK"}` {
+		c.Close()
+		return nil, errors.New("login failed: " + reply)
+	}
+	c.Name = name
+	return c, nil
+}
+
+//Отправляет команду command с данными payload, которые могут быть пустыми
+func (c *fakeClient) Send(command, payload string) error {
+	var line = command
+	if payload != "" {
+		line += " " + payload
+	}
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+//Читает одну строку от сервера
+func (c *fakeClient) Read() (string, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+//Отправляет команду и читает ответ на неё
+func (c *fakeClient) Command(command, payload string) (string, error) {
+	if err := c.Send(command, payload); err != nil {
+		return "", err
+	}
+	return c.Read()
+}
+
+//Ждёт событие event, например SOCKET STARTGAME, и возвращает его данные. Ошибка, если пришло что-то другое
+func (c *fakeClient) Expect(event string) (string, error) {
+	line, err := c.Read()
+	if err != nil {
+		return "", fmt.Errorf("%s: waiting for %s: %w", c.Name, event, err)
+	}
+	if !strings.HasPrefix(line, event+" ") {
+		return "", fmt.Errorf("%s: %s expected, got %s", c.Name, event, line)
+	}
+	return strings.TrimPrefix(line, event+" "), nil
+}
+
+//Закрывает соединение
+func (c *fakeClient) Close() {
+	_ = c.conn.Close()
+}
+
+//Запись сыгранной игры
+type gameRecord struct {
+	Moves []string      //Ходы в порядке игры: логин и поле, которое он прислал
+	Ends  []EndGameInfo //SOCKET ENDGAME каждого игрока в порядке players
+}
+
+//Начинает игру двух игроков, которые уже вошли в одно лобби: передвигает часы на GameStartDelay и ждёт
+//SOCKET STARTGAME. Возвращает поля, которые получили игроки, и индекс ходящего первым
+func (h *harness) StartGame(players [2]*fakeClient) ([2]client.Field, int, error) {
+	var views [2]client.Field
+	if !h.Clock.WaitTimers(1, h.wait) {
+		return views, -1, errors.New("game didn't start")
+	}
+	h.Clock.Advance(GameStartDelay)
+	var mover = -1
+	for i, val := range players {
+		payload, err := val.Expect("SOCKET STARTGAME")
+		if err != nil {
+			return views, -1, err
+		}
+		var start client.StartGame
+		if err = json.Unmarshal([]byte(payload), &start); err != nil {
+			return views, -1, err
+		}
+		_ = json.Unmarshal([]byte(payload), &views[i])
+		if start.Move {
+			mover = i
+		}
+	}
+	if mover < 0 {
+		return views, -1, errors.New("nobody has the first move")
+	}
+	return views, mover, nil
+}
+
+//Проводит до конца игру двух игроков, которые уже вошли в одно лобби: начинает её через StartGame и по очереди
+//отправляет ходы, которые выбирает strategy, пока оба не получат SOCKET ENDGAME. Возвращается после того, как
+//результаты всех игр сохранены. Ответы HIDDEN_CONFLICT Play не ждёт, поэтому игры в тумане войны через него
+//не проводятся
+func (h *harness) Play(players [2]*fakeClient, strategy client.Strategy) (gameRecord, error) {
+	var record gameRecord
+	views, mover, err := h.StartGame(players)
+	if err != nil {
+		return record, err
+	}
+	for {
+		var step = strategy(views[mover])
+		data, _ := json.Marshal(step)
+		record.Moves = append(record.Moves, players[mover].Name+" "+string(data))
+		if err := players[mover].Send("SOCKET STEP", string(data)); err != nil {
+			return record, err
+		}
+		var follower = 1 - mover
+		line, err := players[follower].Read()
+		if err != nil {
+			return record, fmt.Errorf("%s: %w", players[follower].Name, err)
+		}
+		if strings.HasPrefix(line, "SOCKET STEP ") {
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "SOCKET STEP ")), &views[follower]); err != nil {
+				return record, err
+			}
+			mover = follower
+			continue
+		}
+		if !strings.HasPrefix(line, "SOCKET ENDGAME ") {
+			return record, fmt.Errorf("%s: SOCKET STEP or SOCKET ENDGAME expected, got %s", players[follower].Name, line)
+		}
+		record.Ends = make([]EndGameInfo, 2)
+		_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "SOCKET ENDGAME ")), &record.Ends[follower])
+		payload, err := players[mover].Expect("SOCKET ENDGAME")
+		if err != nil {
+			return record, err
+		}
+		_ = json.Unmarshal([]byte(payload), &record.Ends[mover])
+		h.WaitGames()
+		return record, nil
+	}
+}
@@ -1,5 +1,7 @@
 package server
 
+import "encoding/json"
+
 type LoginInfo struct {
 	Login string `json:"LOGIN"`
 }
@@ -8,14 +10,48 @@ type Message struct {
 	Msg string `json:"MESSAGE"`
 }
 
+type RequestID struct {
+	ReqID json.RawMessage `json:"REQ_ID"`
+}
+
+type TaggedResponse struct {
+	ReqID json.RawMessage `json:"REQ_ID"`
+	Data  json.RawMessage `json:"DATA"`
+}
+
+type ErrorResponse struct {
+	Error ErrorInfo `json:"ERROR"`
+}
+
+type ErrorInfo struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter uint32 `json:"retryAfter,omitempty"`
+}
+
 type LobbyInfo struct {
-	ID                 *string `json:"_id"`
-	Width              uint8   `json:"width"`
-	Height             uint8   `json:"height"`
-	GameBarrierCount   uint8   `json:"gameBarrierCount"`
-	PlayerBarrierCount uint8   `json:"playerBarrierCount"`
-	Name               string  `json:"name"`
-	PlayersCount       uint8   `json:"players_count"`
+	ID                 *string    `json:"_id"`
+	Width              uint16     `json:"width"`
+	Height             uint16     `json:"height"`
+	GameBarrierCount   uint16     `json:"gameBarrierCount"`
+	PlayerBarrierCount uint8      `json:"playerBarrierCount"`
+	Name               string     `json:"name"`
+	PlayersCount       uint8      `json:"players_count"`
+	TimeControl        string     `json:"timeControl"`
+	TimeBank           uint32     `json:"timeBank"`
+	Increment          uint32     `json:"increment"`
+	Delay              uint32     `json:"delay"`
+	Seed               int64      `json:"seed"`
+	Generator          string     `json:"generator"`
+	Tolerance          uint8      `json:"tolerance"`
+	Distance           uint16     `json:"distance"`
+	OpponentDistance   uint16     `json:"opponentDistance"`
+	Pair               string     `json:"pair"`
+	FirstPlayer        string     `json:"firstPlayer"`
+	Jumps              bool       `json:"jumps"`
+	Teams              [][]string `json:"teams"`
+	Variant            string     `json:"variant"`
+	Visibility         uint8      `json:"visibility"`
 }
 
 type GetLobbyResponse struct {
@@ -28,20 +64,34 @@ type LobbyID struct {
 }
 
 type StartGameInfo struct {
-	Move             bool          `json:"move"`
-	Width            uint8         `json:"width"`
-	Height           uint8         `json:"height"`
-	Position         [2]uint8      `json:"position"`
-	OpponentPosition [2]uint8      `json:"opponentPosition"`
-	Barriers         [][4][2]uint8 `json:"barriers"`
+	Move             bool       `json:"move"`
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
+	Goals            []string   `json:"goals"`
+	Visible          []bool     `json:"visible,omitempty"`
+	BarriersLeft     uint8      `json:"barriersLeft"`
+	TimeLeft         uint32     `json:"timeLeft"`
+	OpponentTimeLeft uint32     `json:"opponentTimeLeft"`
+	TimesLeft        []uint32   `json:"timesLeft"`
 }
 
 type Field struct {
-	Width            uint8         `json:"width"`
-	Height           uint8         `json:"height"`
-	Position         [2]uint8      `json:"position"`
-	OpponentPosition [2]uint8      `json:"opponentPosition"`
-	Barriers         [][4][2]uint8 `json:"barriers"`
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
+	Goals            []string   `json:"goals"`
+	Visible          []bool     `json:"visible,omitempty"`
+	BarriersLeft     uint8      `json:"barriersLeft"`
+	TimeLeft         uint32     `json:"timeLeft"`
+	OpponentTimeLeft uint32     `json:"opponentTimeLeft"`
+	TimesLeft        []uint32   `json:"timesLeft"`
 }
 
 type Stats struct {
@@ -49,16 +99,42 @@ type Stats struct {
 	Points uint16 `json:"points"`
 }
 
+type PlayerStats struct {
+	Name     string `json:"name"`
+	Moves    uint32 `json:"moves"`
+	Mean     uint32 `json:"mean"`
+	P95      uint32 `json:"p95"`
+	Max      uint32 `json:"max"`
+	Timeouts uint32 `json:"timeouts"`
+}
+
+type PairResult struct {
+	Pair    string    `json:"pair"`
+	Players [2]string `json:"players"`
+	Points  [2]uint16 `json:"points"`
+	Games   uint8     `json:"games"`
+}
+
 type JoinLobbyResponse struct {
 	Data    LobbyInfo `json:"DATA"`
 	Success bool      `json:"SUCCESS"`
 }
 
 type EndGameInfo struct {
-	Result           string        `json:"result"`
-	Width            uint8         `json:"width"`
-	Height           uint8         `json:"height"`
-	Position         [2]uint8      `json:"position"`
-	OpponentPosition [2]uint8      `json:"opponentPosition"`
-	Barriers         [][4][2]uint8 `json:"barriers"`
+	Result           string     `json:"result"`
+	Reason           string     `json:"reason"`
+	Place            uint8      `json:"place"`
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
+	Goals            []string   `json:"goals"`
+	Visible          []bool     `json:"visible,omitempty"`
+	BarriersLeft     uint8      `json:"barriersLeft"`
+	TimeLeft         uint32     `json:"timeLeft"`
+	OpponentTimeLeft uint32     `json:"opponentTimeLeft"`
+	TimesLeft        []uint32   `json:"timesLeft"`
+	MoveTimes        []uint32   `json:"moveTimes"`
 }
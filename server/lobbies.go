@@ -3,306 +3,954 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"goServer/board"
+	"goServer/schema"
+	"math"
 	"math/rand"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
+//Причины окончания игры
+const (
+	ReasonGoal       = "goal"        //Игрок дошёл до противоположного края поля
+	ReasonMaxTurns   = "max_turns"   //Закончились ходы
+	ReasonRepetition = "repetition"  //Позиция повторилась RepetitionLimit раз
+	ReasonNoProgress = "no_progress" //Кратчайшие пути игроков не менялись NoProgressTurns ходов
+	ReasonTime       = "time"        //Игрок не уложился в контроль времени
+	ReasonFormat     = "format"      //Игрок прислал данные в неверном формате
+	ReasonIllegal    = "illegal"     //Игрок сделал недопустимый ход
+	ReasonAdjourned  = "adjourned"   //Игра отложена, например, при остановке сервера
+)
+
+//Порядковые числительные для лога игры
+var ordinals = [4]string{"Первый", "второй", "третий", "четвёртый"}
+
+//Результат игры. Для игры двух игроков и командной игры заполняются first, second и result, для игры большего
+//количества игроков - players и places
 type result struct {
-	first  string
-	second string
-	result string
+	first     string
+	second    string
+	result    string
+	reason    string         //Одна из причин окончания игры Reason*
+	players   []string       //Игроки в порядке ходов
+	places    []uint8        //Места, которые заняли игроки players. При ничьей у всех первое место
+	partners  [2]string      //Напарники first и second в командной игре
+	timings   []moveTiming   //Время каждого хода игры
+	adjourned *adjournedGame //Состояние отложенной игры, если reason - ReasonAdjourned
+}
+
+//Режимы генерации поля
+const (
+	RandomGenerator    = "random"    //Препятствия ставятся случайно, лишь бы у каждого игрока был путь
+	BalancedGenerator  = "balanced"  //Кратчайшие пути игроков отличаются не больше, чем на tolerance
+	SymmetricGenerator = "symmetric" //Поле центрально симметрично
+)
+
+//Сколько раз пытаться сгенерировать сбалансированное поле, прежде чем согласиться на последнее
+const maxBalanceAttempts = 1000
+
+//Сколько работы можно потратить на генерацию одного поля вместе с попытками сбалансировать его. Проверка путей
+//стоит количество игроков, умноженное на площадь поля и на количество стоящих препятствий, попытка поставить
+//препятствие без проверки путей - количество стоящих препятствий. Это около трёх секунд
+const maxGenerationWork = 200000000
+
+//Ошибка генерации поля, если препятствия не удалось расставить за maxGenerationWork
+var errFieldGeneration = newError(ErrInvalidLobby, "can't generate a field with these parameters, try fewer barriers")
+
+//Оставшаяся работа генерации поля
+type generationBudget int
+
+//Списывает работу units. Возвращает false, если работа закончилась
+func (b *generationBudget) spend(units int) bool {
+	*b -= generationBudget(units)
+	return *b >= 0
 }
 
+//Стоимость проверки путей players игроков на поле width x height с barriers препятствиями
+func pathsWork(players, width, height, barriers int) int {
+	return players * width * height * (barriers + 1)
+}
+
+//Наибольшее количество игроков в лобби
+const MaxLobbyPlayers = 4
+
+//Наибольшая ширина и высота поля
+const MaxFieldSize = 100
+
+//Наибольшее количество препятствий на поле. После каждого препятствия генератор проверяет пути всех игроков, и на
+//больших полях с сотнями препятствий генерация занимала бы десятки секунд
+const MaxGameBarriers = 64
+
 //Структура представляющая лобби
 type Lobby struct {
-	Info            LobbyInfo        //Параметры данного лобби, такие как ширина, высота, количество препятствий
-	expectingPlayer *connectedClient //Ожидающий в лобби клиент
-	isPlaying       bool             //Идёт ли игра в данном лобби в данный момент
-	channel         chan string      //Канал, в который игроки пишут свои ходы
-	results         chan result      //Канал, в который отправятся результаты после окончания игры
+	Info             LobbyInfo          //Параметры данного лобби, такие как ширина, высота, количество препятствий
+	expectingPlayers []*connectedClient //Ожидающие в лобби клиенты
+	isPlaying        bool               //Идёт ли игра в данном лобби в данный момент
+	channel          chan playerMove    //Канал, в который игроки пишут свои ходы
+	results          chan result        //Канал, в который отправятся результаты после окончания игры
+	game             string             //Идентификатор текущей игры: ID лобби и время её начала
+	state            *gameState         //Состояние текущей игры, nil, пока игра не началась
+	adjourn          chan struct{}      //Закрывается, когда игру нужно отложить
+	adjournOnce      sync.Once          //Чтобы adjourn закрывался только один раз
+	server           *server            //Сервер, которому принадлежит лобби
+	field            Field              //Начальное поле лобби, генерируется, когда в лобби заходит первый игрок
+}
+
+//Состояние идущей игры. Хранится в лобби, а не в локальных переменных, чтобы игру можно было отложить и потом
+//продолжить
+type gameState struct {
+	players []*connectedClient //Игроки в порядке ходов
+	names   []string           //Логины игроков в порядке ходов
+	field   Field              //Настоящее поле, без тумана войны
+	clock   *chessClock        //Часы игроков
+	budget  *barrierBudget     //Оставшиеся препятствия
+	turn    int                //Номер текущего хода, ходит игрок turn % len(players)
+	draws   *drawTracker       //Наблюдатель за повторениями позиций
+	limits  gameLimits         //Ограничения, с которыми началась игра
+	timings []moveTiming       //Время, потраченное игроками на каждый ход
+	log     []byte             //HTML-лог игры
+}
+
+//Создаёт пустое лобби с параметрами info
+func (s *server) newLobby(info LobbyInfo) *Lobby {
+	var res = new(Lobby)
+	*res = Lobby{
+		Info:             info,
+		expectingPlayers: make([]*connectedClient, 0, MaxLobbyPlayers),
+		isPlaying:        false,
+		channel:          make(chan playerMove, 1),
+		results:          make(chan result, 1),
+		adjourn:          make(chan struct{}),
+		server:           s,
+	}
+	return res
+}
+
+//Ход, присланный игроком
+type playerMove struct {
+	player *connectedClient
+	data   string
+}
+
+//Ограничения игры, которые можно менять перезагрузкой настроек. Игра запоминает их в начале, поэтому
+//перезагрузка действует только на новые игры
+type gameLimits struct {
+	timeout         time.Duration //Таймаут хода
+	maxTurns        int           //Наибольшее количество ходов, после которого будет объявлена ничья
+	repetitionLimit int           //Сколько раз должна повториться позиция, чтобы была объявлена ничья. 0 - правило выключено
+	noProgressTurns int           //Через сколько ходов без изменения кратчайших путей объявляется ничья. 0 - правило выключено
+}
+
+//Берёт ограничения игры из настроек
+func newGameLimits(conf configs) gameLimits {
+	return gameLimits{
+		timeout:         conf.Timeout * time.Second,
+		maxTurns:        conf.MaxTurns,
+		repetitionLimit: conf.RepetitionLimit,
+		noProgressTurns: conf.NoProgressTurns,
+	}
+}
+
+//Ограничения, с которыми начнётся новая игра
+func (s *server) currentLimits() gameLimits {
+	s.limitsMutex.Lock()
+	defer s.limitsMutex.Unlock()
+	return s.limits
+}
+
+//Меняет ограничения для новых игр
+func (s *server) setLimits(val gameLimits) {
+	s.limitsMutex.Lock()
+	s.limits = val
+	s.limitsMutex.Unlock()
+}
+
+//Оставшиеся препятствия игроков. В командной игре у напарников общий запас, вдвое больший запаса одного игрока
+type barrierBudget struct {
+	left  []uint8 //Оставшиеся препятствия каждого игрока или каждой команды
+	teams bool    //Общий ли запас у напарников
 }
 
-//Таймаут ходов
-var Timeout = Server.Configs.Timeout
+//Создаёт запас препятствий для игры count игроков
+func newBarrierBudget(info LobbyInfo, count int) *barrierBudget {
+	var res = new(barrierBudget)
+	res.teams = info.teamGame()
+	if res.teams {
+		res.left = []uint8{2 * info.PlayerBarrierCount, 2 * info.PlayerBarrierCount}
+	} else {
+		res.left = make([]uint8, count)
+		for i := range res.left {
+			res.left[i] = info.PlayerBarrierCount
+		}
+	}
+	return res
+}
+
+//Индекс запаса, из которого ставит препятствия игрок i
+func (b *barrierBudget) owner(i int) int {
+	if b.teams {
+		return i % 2
+	}
+	return i
+}
+
+//Проверяет, является ли игра командной. В командной игре ровно две команды по два игрока
+func (info LobbyInfo) teamGame() bool {
+	return len(info.Teams) == 2
+}
 
-//Наибольшее количество ходов, после которого будет объявлена ничья
-var MaxTurns = Server.Configs.MaxTurns
+//Индекс команды, в которой состоит игрок name, либо -1
+func (info LobbyInfo) teamOf(name string) int {
+	for i, team := range info.Teams {
+		for _, val := range team {
+			if val == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+//Количество игроков, которое нужно для начала игры
+func (l *Lobby) playersCount() int {
+	if l.Info.PlayersCount < 2 {
+		return 2
+	}
+	return int(l.Info.PlayersCount)
+}
+
+//Добавляет клиента к ожидающим. Возвращает true, если лобби заполнилось и можно начинать игру
+func (l *Lobby) addPlayer(client *connectedClient) bool {
+	for _, val := range l.expectingPlayers {
+		if val == client {
+			return false
+		}
+	}
+	l.expectingPlayers = append(l.expectingPlayers, client)
+	return len(l.expectingPlayers) >= l.playersCount()
+}
 
 //Удаляет клиента из лобби
 func (l *Lobby) removePlayer(client *connectedClient) {
 	if l.isPlaying {
 		return
 	}
-	if l.expectingPlayer == client {
-		l.expectingPlayer = nil
+	for i, val := range l.expectingPlayers {
+		if val == client {
+			l.expectingPlayers = append(l.expectingPlayers[:i], l.expectingPlayers[i+1:]...)
+			return
+		}
 	}
 }
 
-////Добавляет клиента в лобби, если в лобби уже есть ожидающий клиент, то начинает игру между ними
-//func (l *Lobby) AddPLayer(client *connectedClient, ch chan error) {
-//	if l.isPlaying {
-//		ch <- errors.New("trying to join lobby that already started a game")
-//		return
-//	} else {
-//		ch <- nil
-//	}
-//	_ = <-ch
-//	if l.expectingPlayer == nil {
-//		l.expectingPlayer = client
-//	} else {
-//		l.isPlaying = true
-//		go l.playGame(l.expectingPlayer, client)
-//		var res = <-l.results
-//		l.Server.deleteLobby(res, l, l.expectingPlayer, client)
-//	}
-//}
+//Расставляет игроков в порядке ходов. В парных играх первый ход заранее закреплён за одним из игроков, иначе
+//порядок случайный. В командной игре команды ходят по очереди, так что напарники стоят через одного
+func (l *Lobby) orderPlayers(players []*connectedClient) []*connectedClient {
+	var res = make([]*connectedClient, len(players))
+	copy(res, players)
+	l.server.rand.Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	if l.Info.FirstPlayer != "" {
+		for i, val := range res {
+			if val.name == l.Info.FirstPlayer {
+				res[0], res[i] = res[i], res[0]
+			}
+		}
+	}
+	if l.Info.teamGame() {
+		var leading = l.Info.teamOf(res[0].name)
+		for i := 1; i < len(res); i++ {
+			var expected = leading
+			if i%2 == 1 {
+				expected = 1 - leading
+			}
+			for j := i; j < len(res); j++ {
+				if l.Info.teamOf(res[j].name) == expected {
+					res[i], res[j] = res[j], res[i]
+					break
+				}
+			}
+		}
+	}
+	return res
+}
 
 //Основной метод, который проводит игру между клиентами
-func (l *Lobby) playGame(player1 *connectedClient, player2 *connectedClient) {
-	fmt.Printf("Game between %s and %s started!\n", player1.name, player2.name)
-	var first, second = func() (*connectedClient, *connectedClient) {
-		if rand.Uint32()&1 == 0 {
-			return player1, player2
+func (l *Lobby) playGame(players []*connectedClient) {
+	players = l.orderPlayers(players)
+	var names = clientNames(players)
+	var field = l.field
+	var limits = l.server.currentLimits()
+	var state = new(gameState)
+	*state = gameState{
+		players: players,
+		names:   names,
+		field:   field,
+		clock:   newChessClock(l.Info, len(players), limits.timeout),
+		budget:  newBarrierBudget(l.Info, len(players)),
+		draws:   newDrawTracker(field, 0, 0, l.Info.rules(), limits),
+		limits:  limits,
+		log:     l.initLog(names, l.Info.teamGame(), l.Info.Variant),
+	}
+	l.state = state
+	l.game = fmt.Sprintf("%s_%s", *l.Info.ID, l.server.clock.Now().Format(time.StampMicro))
+	l.log().Info("game started", "players", names, "variant", l.Info.Variant)
+	l.server.metrics.gamesStarted.Add(1)
+	l.continueGame()
+}
+
+//Логины клиентов
+func clientNames(players []*connectedClient) []string {
+	var names = make([]string, len(players))
+	for i, val := range players {
+		names[i] = val.name
+	}
+	return names
+}
+
+//Проводит игру из текущего состояния до конца: рассылает игрокам поле, принимает ходы и отправляет результаты
+func (l *Lobby) continueGame() {
+	var g = l.state
+	for _, val := range g.players {
+		val.AddListener(l.getTurn)
+	}
+	for i, val := range g.players {
+		var view = g.field.view(i, g.clock, g.budget, l.Info.visibility())
+		var startGameInfo = StartGameInfo{
+			Move:             i == g.turn%len(g.players),
+			Width:            view.Width,
+			Height:           view.Height,
+			Position:         view.Position,
+			OpponentPosition: view.OpponentPosition,
+			Barriers:         view.Barriers,
+			Positions:        view.Positions,
+			Goals:            view.Goals,
+			Visible:          view.Visible,
+			BarriersLeft:     view.BarriersLeft,
+			TimeLeft:         view.TimeLeft,
+			OpponentTimeLeft: view.OpponentTimeLeft,
+			TimesLeft:        view.TimesLeft,
+		}
+		data, _ := json.Marshal(startGameInfo)
+		val.SendData([]byte(fmt.Sprintf("SOCKET STARTGAME %s\n", string(data))))
+	}
+	var winner, offender, reason = l.runGame()
+	for _, val := range g.players {
+		_, _ = funcPop(&val.dataReceivedListeners)
+	}
+	for _, val := range g.players {
+		val.readMutex.Lock()
+	}
+	if reason == ReasonAdjourned {
+		l.adjournPlayers()
+		return
+	}
+	var players, names, field = g.players, g.names, g.field
+	var places = placements(field, winner, offender, reason, g.budget.teams, l.Info.rules())
+	l.log().Info("game finished", "players", names, "places", fmt.Sprint(places), "reason", reason)
+	l.server.metrics.gameFinished(reason)
+	var res = result{
+		reason:  reason,
+		players: names,
+		places:  places,
+		timings: g.timings,
+	}
+	if g.budget.teams {
+		res.partners = [2]string{names[2], names[3]}
+	}
+	if len(players) == 2 || g.budget.teams {
+		res.first, res.second = names[0], names[1]
+		switch {
+		case places[0] == places[1]:
+			res.result = "draw"
+		case places[0] == 1:
+			res.result = "first"
+		default:
+			res.result = "second"
+		}
+	}
+	var re = regexp.MustCompile("<!--RESULT-->")
+	var timesLog = "<br>Время ходов:" + timingsLog(names, g.timings)
+	if isDraw(reason) {
+		g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Ничья!%s", timesLog)))
+	} else {
+		var standings strings.Builder
+		if g.budget.teams {
+			var team = winnerIndex(places)
+			standings.WriteString(fmt.Sprintf("Победила команда %s и %s", names[team], names[team+2]))
 		} else {
-			return player2, player1
-		}
-	}()
-	first.AddListener(l.getTurn)
-	second.AddListener(l.getTurn)
-	//fmt.Printf("First: my opponent is %s\n", first.Lobby.opponent.name)
-	//fmt.Printf("Second: my opponent is %s\n", second.Lobby.opponent.name)
-	var field = l.generateRandomField()
-	var startGameInfo = StartGameInfo{
-		Move:             true,
-		Width:            l.Info.Width,
-		Height:           l.Info.Height,
-		Position:         field.Position,
-		OpponentPosition: field.OpponentPosition,
-		Barriers:         field.Barriers,
-	}
-	sendStartGameInfo(first, second, &startGameInfo)
-	var ch = make(chan *connectedClient, 1)
-	var log = initLog(first, second)
-	var re *regexp.Regexp
-	go func() {
-		x := 0
-		leader, follower := first, second
-		l.writeToLog(&log, &field, x-1, first.name == follower.name)
-		for {
-			select {
-			//Если ответ пришёл вовремя
-			case res := <-l.channel:
-				err := json.Unmarshal([]byte(res), &field)
-				//Если получен ответ в неверном формате
-				if err != nil {
-					re = regexp.MustCompile("<!--COMMENTS-->")
-					log = re.ReplaceAll(log, []byte(fmt.Sprintf("Победил игрок %s так как игрок %s не смог прислать данные в верном формате\n", follower.name, leader.name)))
-					ch <- follower
-					return
-				} else { // Ответ в нормальном формате
-					if winner, ok := isEnded(x, field, first, second, first.name == follower.name); !ok { //Если игра не закончилась
-						l.writeToLog(&log, &field, x, first.name == follower.name)
-						field.Position, field.OpponentPosition = field.OpponentPosition, field.Position
-						d, _ := json.Marshal(field)
-						follower.SendData([]byte(fmt.Sprintf("SOCKET STEP %s\n", string(d))))
-						leader, follower = follower, leader
-						x += 1
-					} else { //Если закончилась
-						field.Position, field.OpponentPosition = field.OpponentPosition, field.Position
-						leader, follower = follower, leader
-						l.writeToLog(&log, &field, x, first.name == follower.name)
-						ch <- winner
-						return
-					}
-				}
-			//Если ответ не пришёл вовремя
-			case <-time.After(Timeout * time.Second):
-				re = regexp.MustCompile("<!--COMMENTS-->")
-				log = re.ReplaceAll(log, []byte(fmt.Sprintf("Победил игрок %s так как игрок %s не ответил вовремя\n", follower.name, leader.name)))
-				ch <- follower
-				return
+			standings.WriteString(fmt.Sprintf("Победил игрок %s", names[winnerIndex(places)]))
+		}
+		if len(players) > 2 && !g.budget.teams {
+			for i, val := range places {
+				standings.WriteString(fmt.Sprintf("<br>%d место - %s", val, names[i]))
 			}
 		}
-	}()
-	var winner = <-ch
-	_, _ = funcPop(&first.dataReceivedListeners)
-	_, _ = funcPop(&second.dataReceivedListeners)
-	first.readMutex.Lock()
-	second.readMutex.Lock()
-	var endGame = EndGameInfo{
-		Width:            l.Info.Width,
-		Height:           l.Info.Height,
-		Position:         field.Position,
-		OpponentPosition: field.OpponentPosition,
-		Barriers:         field.Barriers,
-	}
-	if winner == nil { //Ничья
-		re = regexp.MustCompile("<!--RESULT-->")
-		log = re.ReplaceAll(log, []byte(fmt.Sprintf("Ничья!")))
-		l.results <- result{
-			first:  first.name,
-			second: second.name,
-			result: "draw",
-		}
-		endGame.Result = "draw"
-		res, _ := json.Marshal(endGame)
-		first.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(res))))
-		endGame.Position, endGame.OpponentPosition = endGame.OpponentPosition, endGame.Position
-		second.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(res))))
-	} else if winner.name == first.name {
-		re = regexp.MustCompile("<!--RESULT-->")
-		log = re.ReplaceAll(log, []byte(fmt.Sprintf("Победил игрок %s", winner.name)))
-		l.results <- result{
-			first:  first.name,
-			second: second.name,
-			result: "first",
-		}
-		endGame.Result = "win"
-		res, _ := json.Marshal(endGame)
-		first.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(res))))
-		endGame.Result = "lose"
-		endGame.Position, endGame.OpponentPosition = endGame.OpponentPosition, endGame.Position
-		res, _ = json.Marshal(endGame)
-		second.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(res))))
-	} else {
-		re = regexp.MustCompile("<!--RESULT-->")
-		log = re.ReplaceAll(log, []byte(fmt.Sprintf("Победил игрок %s", winner.name)))
-		l.results <- result{
-			first:  first.name,
-			second: second.name,
-			result: "second",
-		}
-		endGame.Result = "win"
-		res, _ := json.Marshal(endGame)
-		second.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(res))))
-		endGame.Result = "lose"
-		endGame.Position, endGame.OpponentPosition = endGame.OpponentPosition, endGame.Position
-		res, _ = json.Marshal(endGame)
-		first.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(res))))
-	}
-	log = bytes.Trim(log, "\x00")
-	logFile, err2 := os.Create(fmt.Sprintf("logs/%s_vs_ %s_%s.html", first.name, second.name, time.Now().Format(time.StampMicro)))
+		standings.WriteString(timesLog)
+		g.log = re.ReplaceAll(g.log, []byte(standings.String()))
+	}
+	l.results <- res
+	for i, val := range players {
+		var view = field.view(i, g.clock, g.budget, l.Info.visibility())
+		var endGame = EndGameInfo{
+			Result:           "lose",
+			Reason:           reason,
+			Place:            places[i],
+			Width:            view.Width,
+			Height:           view.Height,
+			Position:         view.Position,
+			OpponentPosition: view.OpponentPosition,
+			Barriers:         view.Barriers,
+			Positions:        view.Positions,
+			Goals:            view.Goals,
+			Visible:          view.Visible,
+			BarriersLeft:     view.BarriersLeft,
+			TimeLeft:         view.TimeLeft,
+			OpponentTimeLeft: view.OpponentTimeLeft,
+			TimesLeft:        view.TimesLeft,
+			MoveTimes:        playerTimes(g.timings, i),
+		}
+		if isDraw(reason) {
+			endGame.Result = "draw"
+		} else if places[i] == 1 {
+			endGame.Result = "win"
+		}
+		data, _ := json.Marshal(endGame)
+		val.SendData([]byte(fmt.Sprintf("SOCKET ENDGAME %s\n", string(data))))
+	}
+	l.writeLogFile()
+}
+
+//Сохраняет HTML-лог игры в папку logs
+func (l *Lobby) writeLogFile() {
+	var log = bytes.Trim(l.state.log, "\x00")
+	logFile, err2 := os.Create(fmt.Sprintf("logs/%s_%s.html", strings.Join(l.state.names, "_vs_ "), l.server.clock.Now().Format(time.StampMicro)))
 	if err2 != nil {
-		println(err2.Error())
+		l.log().Error("can't write game log", "err", err2)
 	} else {
 		_, _ = logFile.Write(log)
 		logFile.Close()
 	}
 }
 
-func initLog(first *connectedClient, second *connectedClient) []byte {
+//Принимает ходы игроков по очереди, пока игра не закончится. Возвращает индекс победителя и индекс игрока,
+//нарушившего правила или не уложившегося во время (-1, если таких нет), и причину окончания игры
+func (l *Lobby) runGame() (int, int, string) {
+	var g = l.state
+	var re = regexp.MustCompile("<!--COMMENTS-->")
+	var players, clock, budget = g.players, g.clock, g.budget
+	x := g.turn
+	l.writeToLog(x - 1)
+	for {
+		var mover = x % len(players)
+		var leader, follower = players[mover], players[(mover+1)%len(players)]
+		var moveStarted = l.server.clock.Now()
+		g.turn = x
+		var step Field
+		var err error
+		for {
+			//После отклонённого хода игрок ходит ещё раз в оставшееся у него время
+			res, ok := l.waitMove(leader, clock.budget(mover)-l.server.clock.Now().Sub(moveStarted))
+			//Если игру откладывают, то время хода игроку не засчитывается
+			if !ok && l.adjourned() {
+				g.log = re.ReplaceAll(g.log, []byte("Игра отложена\n"))
+				return -1, -1, ReasonAdjourned
+			}
+			//Если ответ не пришёл вовремя
+			if !ok {
+				g.timings = append(g.timings, moveTiming{player: mover, turn: x, think: clock.budget(mover), timeout: true})
+				clock.punch(mover, clock.budget(mover))
+				g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Игрок %s проиграл так как не ответил вовремя\n", leader.name)))
+				return -1, mover, ReasonTime
+			}
+			step, err = parseStep(l.server.protocol, res, mover, len(players))
+			//В тумане войны игрок присылает своё поле, а проверяется ход по настоящему
+			if err == nil && l.Info.visibility() > 0 {
+				var seen = step
+				step, err = revealStep(g.field, step, mover, l.Info.visibility())
+				//Ход, который допустим на видимой части поля, но противоречит невидимым игрокам или препятствиям,
+				//отклоняется: игрок не мог знать о них
+				if err == nil && !l.isLegalStep(g.field, step, mover, budget.left[budget.owner(mover)]) &&
+					l.isLegalStep(g.field.filtered(mover, l.Info.visibility()), seen, mover, budget.left[budget.owner(mover)]) {
+					l.server.metrics.protocolErrors.inc("hidden_conflict")
+					l.log().Info("move conflicts with hidden state", "client", leader.name)
+					leader.sendGameError(ErrHiddenConflict, "move conflicts with players or barriers you can't see, move again")
+					continue
+				}
+			}
+			break
+		}
+		var think = l.server.clock.Now().Sub(moveStarted)
+		//Если игрок не уложился в своё время, пока присылал ответ
+		if !clock.punch(mover, think) {
+			g.timings = append(g.timings, moveTiming{player: mover, turn: x, think: think, timeout: true})
+			g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Игрок %s проиграл так как у него закончилось время\n", leader.name)))
+			return -1, mover, ReasonTime
+		}
+		g.timings = append(g.timings, moveTiming{player: mover, turn: x, think: think})
+		l.server.metrics.moveLatency.observe(think)
+		//Если получен ответ в неверном формате
+		if err != nil {
+			l.server.metrics.protocolErrors.inc("move_format")
+			l.log().Info("wrong move format", "client", leader.name, "err", err)
+			g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Игрок %s проиграл так как не смог прислать данные в верном формате\n", leader.name)))
+			return -1, mover, ReasonFormat
+		}
+		//Если ход противоречит правилам
+		if !l.isLegalStep(g.field, step, mover, budget.left[budget.owner(mover)]) {
+			g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Игрок %s проиграл так как сделал недопустимый ход\n", leader.name)))
+			return -1, mover, ReasonIllegal
+		}
+		if len(step.Barriers) > len(g.field.Barriers) {
+			budget.left[budget.owner(mover)] -= 1
+		}
+		g.field = step
+		l.writeToLog(x)
+		if board.GoalEdges[mover].Reached(g.field.Positions[mover], g.field.Width, g.field.Height) {
+			return mover, -1, ReasonGoal
+		}
+		if x >= g.limits.maxTurns {
+			return -1, -1, ReasonMaxTurns
+		}
+		switch g.draws.update(g.field, (mover+1)%len(players), x) {
+		case ReasonRepetition:
+			g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Ничья, так как позиция встретилась в %d-й раз\n", g.limits.repetitionLimit)))
+			return -1, -1, ReasonRepetition
+		case ReasonNoProgress:
+			g.log = re.ReplaceAll(g.log, []byte(fmt.Sprintf("Ничья, так как кратчайшие пути игроков не менялись, ходов подряд: %d\n", g.limits.noProgressTurns)))
+			return -1, -1, ReasonNoProgress
+		}
+		d, _ := json.Marshal(g.field.view((mover+1)%len(players), clock, budget, l.Info.visibility()))
+		follower.SendData([]byte(fmt.Sprintf("SOCKET STEP %s\n", string(d))))
+		x += 1
+	}
+}
+
+//Ждёт ход игрока player не дольше budget. Ходы, присланные другими игроками не в свою очередь, отбрасываются
+func (l *Lobby) waitMove(player *connectedClient, budget time.Duration) (string, bool) {
+	var timer = l.server.clock.NewTimer(budget)
+	defer timer.Stop()
+	for {
+		select {
+		case move := <-l.channel:
+			if move.player == player {
+				return move.data, true
+			}
+			l.log().Warn("move out of turn", "client", move.player.name)
+			l.server.metrics.protocolErrors.inc("out_of_turn")
+		case <-timer.C():
+			return "", false
+		case <-l.adjourn:
+			return "", false
+		}
+	}
+}
+
+//Разбирает поле, присланное игроком mover, и переводит его из вида этого игрока в общий. В игре двух игроков
+//позиции берутся из position и opponentPosition, в игре большего количества - из positions. Данные проверяются
+//по спецификации протокола spec
+func parseStep(spec *schema.Spec, str string, mover, playersCount int) (Field, error) {
+	if err := spec.Validate("Move", []byte(str)); err != nil {
+		return Field{}, err
+	}
+	var step Field
+	err := json.Unmarshal([]byte(str), &step)
+	if err != nil {
+		return Field{}, err
+	}
+	if playersCount == 2 {
+		step.Positions = [][2]int{step.Position, step.OpponentPosition}
+	}
+	if len(step.Positions) != playersCount {
+		return Field{}, errors.New("wrong number of positions")
+	}
+	var positions = make([][2]int, playersCount)
+	for i, val := range step.Positions {
+		positions[(mover+i)%playersCount] = val
+	}
+	step.Positions = positions
+	step.Position, step.OpponentPosition = positions[0], positions[1]
+	step.Goals, step.TimesLeft, step.Visible = nil, nil, nil
+	return step, nil
+}
+
+//Вид поля со стороны игрока i: позиции, цели и время игроков начинаются с самого игрока i, а дальше идут в
+//порядке ходов. В командной игре напарник игрока всегда третий. Если visibility > 0, то игрок видит только то,
+//что не дальше visibility клеток от него
+func (f Field) view(i int, clock *chessClock, budget *barrierBudget, visibility uint8) Field {
+	if visibility > 0 {
+		f = f.filtered(i, visibility)
+	}
+	var res = f
+	var count = len(f.Positions)
+	res.Positions = make([][2]int, count)
+	res.Goals = make([]string, count)
+	res.TimesLeft = make([]uint32, count)
+	if f.Visible != nil {
+		res.Visible = make([]bool, count)
+	}
+	for j := 0; j < count; j++ {
+		res.Positions[j] = f.Positions[(i+j)%count]
+		res.Goals[j] = board.EdgeNames[board.GoalEdges[(i+j)%count]]
+		if f.Visible != nil {
+			res.Visible[j] = f.Visible[(i+j)%count]
+		}
+		res.TimesLeft[j] = clock.left((i + j) % count)
+	}
+	res.Position, res.OpponentPosition = res.Positions[0], res.Positions[1]
+	res.TimeLeft, res.OpponentTimeLeft = res.TimesLeft[0], res.TimesLeft[1]
+	res.BarriersLeft = budget.left[budget.owner(i)]
+	return res
+}
+
+//Поле, каким его видит игрок i в тумане войны. Игрок видит других игроков и препятствия, если они не дальше
+//visibility клеток от него по вертикали и горизонтали. Позиции невидимых игроков заменяются на hiddenCell
+func (f Field) filtered(i int, visibility uint8) Field {
+	var res = f
+	var own = f.Positions[i]
+	res.Positions = make([][2]int, len(f.Positions))
+	res.Visible = make([]bool, len(f.Positions))
+	for j, val := range f.Positions {
+		res.Visible[j] = j == i || isVisible(own, val, visibility)
+		res.Positions[j] = board.HiddenCell
+		if res.Visible[j] {
+			res.Positions[j] = val
+		}
+	}
+	res.Barriers = make([][][2]int, 0, len(f.Barriers))
+	for _, barrier := range f.Barriers {
+		for _, cell := range barrier {
+			if isVisible(own, cell, visibility) {
+				res.Barriers = append(res.Barriers, barrier)
+				break
+			}
+		}
+	}
+	return res
+}
+
+//Проверяет, видна ли клетка cell из клетки from
+func isVisible(from, cell [2]int, visibility uint8) bool {
+	return absDiff(from[1], cell[1]) <= int(visibility) && absDiff(from[0], cell[0]) <= int(visibility)
+}
+
+//Модуль разности двух чисел
+func absDiff(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+//Переносит ход игрока mover из его вида поля в настоящее поле full. Препятствия и другие игроки в присланном
+//поле должны совпадать с тем, что видел игрок, а сам ход потом проверяется по настоящему полю
+func revealStep(full, step Field, mover int, visibility uint8) (Field, error) {
+	var seen = full.filtered(mover, visibility)
+	for i, val := range seen.Positions {
+		if i != mover && step.Positions[i] != val {
+			return Field{}, errors.New("positions differ from the player's view")
+		}
+	}
+	if len(step.Barriers) < len(seen.Barriers) || len(step.Barriers) > len(seen.Barriers)+1 {
+		return Field{}, errors.New("barriers differ from the player's view")
+	}
+	for i, val := range seen.Barriers {
+		if !board.SameBarrier(step.Barriers[i], val) {
+			return Field{}, errors.New("barriers differ from the player's view")
+		}
+	}
+	var res = full
+	res.Positions = append([][2]int{}, full.Positions...)
+	res.Positions[mover] = step.Positions[mover]
+	res.Position, res.OpponentPosition = res.Positions[0], res.Positions[1]
+	res.Barriers = full.Barriers[:len(full.Barriers):len(full.Barriers)]
+	if len(step.Barriers) > len(seen.Barriers) {
+		res.Barriers = append(res.Barriers, step.Barriers[len(seen.Barriers)])
+	}
+	return res, nil
+}
+
+//Распределяет места после окончания игры. Победитель занимает первое место, нарушитель - последнее, остальные
+//игроки - по длине оставшегося им кратчайшего пути. Если закончились ходы, то у всех первое место. В командной
+//игре места общие: команда победителя или соперники нарушителя первые, другая команда вторая
+func placements(f Field, winner, offender int, reason string, teams bool, rules board.Variant) []uint8 {
+	var count = len(f.Positions)
+	var places = make([]uint8, count)
+	if isDraw(reason) {
+		for i := range places {
+			places[i] = 1
+		}
+		return places
+	}
+	if teams {
+		var winnerTeam = winner % 2
+		if winner < 0 {
+			winnerTeam = 1 - offender%2
+		}
+		for i := range places {
+			places[i] = 2
+			if i%2 == winnerTeam {
+				places[i] = 1
+			}
+		}
+		return places
+	}
+	var distances = make([]int, count)
+	for i, val := range f.Positions {
+		switch i {
+		case winner:
+			distances[i] = -1
+		case offender:
+			distances[i] = math.MaxInt32
+		default:
+			distances[i] = board.ShortestPath(val, board.GoalEdges[i], f.Barriers, f.Width, f.Height, rules)
+		}
+	}
+	for i := range places {
+		places[i] = 1
+		for j := range places {
+			if distances[j] < distances[i] {
+				places[i] += 1
+			}
+		}
+	}
+	return places
+}
+
+//Индекс игрока, занявшего первое место
+func winnerIndex(places []uint8) int {
+	for i, val := range places {
+		if val == 1 {
+			return i
+		}
+	}
+	return 0
+}
+
+func (l *Lobby) initLog(names []string, teams bool, variant string) []byte {
 	file, err := os.Open("resources/template.html")
 	var log = make([]byte, 1024*100)
 	if err != nil {
-		println(err.Error())
+		l.log().Error("can't open log template", "err", err)
 	} else {
 		_, err2 := file.Read(log)
 		defer file.Close()
 		if err2 != nil {
-			println(err2.Error())
+			l.log().Error("can't read log template", "err", err2)
 		}
 	}
 	re := regexp.MustCompile("<!--NAME-->")
-	log = re.ReplaceAll(log, []byte(fmt.Sprintf("%s vs %s %s", first.name, second.name, time.Now().Format(time.RFC822))))
+	log = re.ReplaceAll(log, []byte(fmt.Sprintf("%s %s", strings.Join(names, " vs "), time.Now().Format(time.RFC822))))
+	var gameName = make([]string, len(names))
+	for i, val := range names {
+		gameName[i] = fmt.Sprintf("%s игрок - %s", ordinals[i], val)
+		if teams {
+			gameName[i] += fmt.Sprintf(" (команда %d)", i%2+1)
+		}
+	}
+	if variant == "" {
+		variant = board.ClassicVariant
+	}
 	re = regexp.MustCompile("<!--GAME NAME-->")
-	log = re.ReplaceAll(log, []byte(fmt.Sprintf("Первый игрок - %s, второй игрок %s", first.name, second.name)))
+	log = re.ReplaceAll(log, []byte(fmt.Sprintf("%s. Правила: %s", strings.Join(gameName, ", "), variant)))
 	return log
 }
 
-func sendStartGameInfo(first *connectedClient, second *connectedClient, startGameInfo *StartGameInfo) {
-	data, _ := json.Marshal(startGameInfo)
-	first.SendData([]byte(fmt.Sprintf("SOCKET STARTGAME %s\n", string(data))))
-	startGameInfo.Move = false
-	startGameInfo.Position, startGameInfo.OpponentPosition = startGameInfo.OpponentPosition, startGameInfo.Position
-	data, _ = json.Marshal(startGameInfo)
-	second.SendData([]byte(fmt.Sprintf("SOCKET STARTGAME %s\n", string(data))))
-}
-
-//Проверяет, зкаончилась ли игра на данном этапе. x - номер текущего хода, f - состояние поля, first, second -
-//клиенты в том порядке, в котором начали игру, swapped - если поле присланно вторым клиентом
-func isEnded(x int, f Field, first, second *connectedClient, swapped bool) (*connectedClient, bool) {
-	var player1, player2 [2]uint8
-	if swapped {
-		player1 = f.OpponentPosition
-		player2 = f.Position
-	} else {
-		player1 = f.Position
-		player2 = f.OpponentPosition
+//Проверяет, что по параметрам лобби можно сгенерировать поле
+func checkFieldParams(info LobbyInfo) error {
+	if info.Width < 2 || info.Height < 2 || info.Width > MaxFieldSize || info.Height > MaxFieldSize {
+		return fmt.Errorf("field size must be from 2x2 to %dx%d", MaxFieldSize, MaxFieldSize)
+	}
+	if uint(info.GameBarrierCount) > uint(info.Width)*uint(info.Height)/4 || info.GameBarrierCount > MaxGameBarriers {
+		return fmt.Errorf("too many barriers for this field, at most a quarter of cells and no more than %d", MaxGameBarriers)
 	}
-	if player1[0] == f.Height-1 {
-		return first, true
+	if info.PlayersCount == 1 || info.PlayersCount > MaxLobbyPlayers {
+		return errors.New("lobby must have from 2 to 4 players")
 	}
-	if player2[0] == 0 {
-		return second, true
+	if info.PlayersCount > 2 && (info.Width < 3 || info.Height < 3) {
+		return errors.New("field for more than 2 players must be at least 3x3")
 	}
-	if x >= MaxTurns {
-		return nil, true
+	if info.PlayersCount == 3 && info.Generator == SymmetricGenerator {
+		return errors.New("field for 3 players can't be symmetric")
 	}
-	return nil, false
+	if err := checkVariant(info); err != nil {
+		return err
+	}
+	if len(info.Teams) > 0 {
+		if info.PlayersCount != 4 || len(info.Teams) != 2 || len(info.Teams[0]) != 2 || len(info.Teams[1]) != 2 {
+			return errors.New("team game must have 4 players in 2 teams")
+		}
+		var names = map[string]bool{}
+		for _, team := range info.Teams {
+			for _, val := range team {
+				names[val] = true
+			}
+		}
+		if len(names) != 4 {
+			return errors.New("team members must be different")
+		}
+	}
+	return nil
 }
 
-//Генерирует случайное допустимое поле
-func (l *Lobby) generateRandomField() Field {
-	var position = [2]uint8{0, uint8(rand.Uint32()) % l.Info.Width}
-	var opponentPosition = [2]uint8{l.Info.Height - 1, uint8(rand.Uint32()) % l.Info.Width}
-	barriers := generateBarriers(position, opponentPosition, l.Info.GameBarrierCount, l.Info.Width, l.Info.Height)
+//Генерирует поле по параметрам лобби. Одно и то же зерно info.Seed при одинаковых параметрам лобби всегда даёт
+//одно и то же поле. Возвращает errFieldGeneration, если за maxGenerationWork не получилось ни одного поля
+func generateField(info LobbyInfo) (Field, error) {
+	var rnd = rand.New(rand.NewSource(info.Seed))
+	var budget = generationBudget(maxGenerationWork)
+	var field Field
+	for attempt := 0; attempt <= maxBalanceAttempts; attempt++ {
+		var candidate, err = generateCandidate(rnd, info, &budget)
+		if err != nil {
+			if attempt == 0 {
+				return Field{}, err
+			}
+			//Сбалансированное поле не нашлось, остаётся последнее
+			break
+		}
+		field = candidate
+		if info.Generator != BalancedGenerator ||
+			!budget.spend(pathsWork(len(field.Positions), field.Width, field.Height, len(field.Barriers))) {
+			break
+		}
+		var distance = fieldDistances(field, info.rules())
+		var min, max = distance[0], distance[0]
+		for _, val := range distance {
+			if val < min {
+				min = val
+			}
+			if val > max {
+				max = val
+			}
+		}
+		if max-min <= int(info.Tolerance) {
+			break
+		}
+	}
+	return field, nil
+}
+
+//Генерирует одно поле, не проверяя его сбалансированность. Первый игрок начинает на верхнем краю, второй - на
+//нижнем, третий - на левом, четвёртый - на правом
+func generateCandidate(rnd *rand.Rand, info LobbyInfo, budget *generationBudget) (Field, error) {
+	var count = int(info.PlayersCount)
+	if count < 2 {
+		count = 2
+	}
+	var positions = make([][2]int, count)
+	var barriers [][][2]int
+	var err error
+	var rules = info.rules()
+	var width, height = int(info.Width), int(info.Height)
+	if info.Generator == SymmetricGenerator {
+		var column = rnd.Intn(width)
+		positions[0] = [2]int{0, column}
+		positions[1] = mirrorCell(positions[0], width, height)
+		if count == 4 {
+			positions[2] = [2]int{1 + rnd.Intn(height-2), 0}
+			positions[3] = mirrorCell(positions[2], width, height)
+		}
+		barriers, err = generateSymmetricBarriers(rnd, positions, int(info.GameBarrierCount), width, height, rules, budget)
+	} else {
+		positions[0] = [2]int{0, rnd.Intn(width)}
+		positions[1] = [2]int{height - 1, rnd.Intn(width)}
+		if count > 2 {
+			positions[2] = [2]int{1 + rnd.Intn(height-2), 0}
+		}
+		if count > 3 {
+			positions[3] = [2]int{1 + rnd.Intn(height-2), width - 1}
+		}
+		barriers, err = generateBarriers(rnd, positions, int(info.GameBarrierCount), width, height, rules, budget)
+	}
+	if err != nil {
+		return Field{}, err
+	}
 	var field = Field{
-		Width:            l.Info.Width,
-		Height:           l.Info.Height,
-		Position:         position,
-		OpponentPosition: opponentPosition,
+		Width:            width,
+		Height:           height,
+		Position:         positions[0],
+		OpponentPosition: positions[1],
 		Barriers:         barriers,
+		Positions:        positions,
 	}
-	return field
+	return field, nil
+}
+
+//Длины кратчайших путей всех игроков до их целей по правилам rules
+func fieldDistances(f Field, rules board.Variant) []int {
+	var res = make([]int, len(f.Positions))
+	for i, val := range f.Positions {
+		res[i] = board.ShortestPath(val, board.GoalEdges[i], f.Barriers, f.Width, f.Height, rules)
+	}
+	return res
 }
 
-func (l *Lobby) writeToLog(log *[]byte, f *Field, x int, swapped bool) {
+//Дописывает в лог состояние поля после хода x. В тумане войны после настоящего поля дописываются виды всех игроков
+func (l *Lobby) writeToLog(x int) {
+	var g = l.state
+	var f = &g.field
 	re := regexp.MustCompile("<!--TURNS-->")
-	var table = make([]uint8, f.Width*f.Height)
-	var player1 = func() [2]uint8 {
-		if swapped {
-			return f.OpponentPosition
-		} else {
-			return f.Position
+	var str strings.Builder
+	if last := len(g.timings) - 1; x >= 0 && last >= 0 && g.timings[last].turn == x {
+		str.WriteString(fmt.Sprintf("<p>Ход номер %d, игрок думал %d мс</p>\n", x+1, g.timings[last].millis()))
+	} else {
+		str.WriteString(fmt.Sprintf("<p>Ход номер %d</p>\n", x+1))
+	}
+	str.WriteString(fieldTable(f))
+	if visibility := l.Info.visibility(); visibility > 0 {
+		for i := range f.Positions {
+			var seen = f.filtered(i, visibility)
+			str.WriteString(fmt.Sprintf("<p>Вид игрока %d</p>\n", i+1))
+			str.WriteString(fieldTable(&seen))
 		}
-	}()
-	var player2 = func() [2]uint8 {
-		if !swapped {
-			return f.OpponentPosition
-		} else {
-			return f.Position
+	}
+	str.WriteString("<!--TURNS-->")
+	g.log = re.ReplaceAll(g.log, []byte(str.String()))
+
+}
+
+//HTML-таблица с полем f. Невидимые игроки не рисуются
+func fieldTable(f *Field) string {
+	var table = make([]uint8, f.Width*f.Height)
+	// 1 - player1
+	// 2 - player2
+	// 1 << 6 - player3
+	// 1 << 7 - player4
+	for i, val := range f.Positions {
+		if val == board.HiddenCell {
+			continue
 		}
-	}()
-	table[player1[0]*f.Width+player1[1]] = 1
-	table[player2[0]*f.Width+player2[1]] = 2
+		table[val[0]*f.Width+val[1]] |= [4]uint8{1, 2, 1 << 6, 1 << 7}[i]
+	}
 	for _, val := range f.Barriers {
 		// 1 << 2 - top
 		// 1 << 3 - bot
 		// 1 << 4 - left
 		// 1 << 5 - right
-		var rest1, rest2 = defineRestrictions(val[0], val[1])
-		table[val[0][0]*f.Width+val[0][1]] += rest1
-		table[val[1][0]*f.Width+val[1][1]] += rest2
-		rest1, rest2 = defineRestrictions(val[2], val[3])
-		table[val[2][0]*f.Width+val[2][1]] += rest1
-		table[val[3][0]*f.Width+val[3][1]] += rest2
+		for i := 0; i+1 < len(val); i += 2 {
+			var rest1, rest2 = defineRestrictions(val[i], val[i+1])
+			table[val[i][0]*f.Width+val[i][1]] |= rest1
+			table[val[i+1][0]*f.Width+val[i+1][1]] |= rest2
+		}
 	}
 	var str strings.Builder
-	str.WriteString(fmt.Sprintf("<p>Ход номер %d</p>\n<table>\n", x+1))
-	for i := uint8(0); i < f.Height; i++ {
+	str.WriteString("<table>\n")
+	for i := 0; i < f.Height; i++ {
 		str.WriteString("<tr>")
-		for j := uint8(0); j < f.Width; j++ {
+		for j := 0; j < f.Width; j++ {
 			str.WriteString(getStrFromAttr(table[i*f.Width+j]))
 		}
 		str.WriteString("</tr>\n")
 	}
-	str.WriteString("</table>\n<!--TURNS-->")
-	*log = re.ReplaceAll(*log, []byte(str.String()))
-
+	str.WriteString("</table>\n")
+	return str.String()
 }
 
 func getStrFromAttr(b uint8) string {
@@ -320,6 +968,12 @@ func getStrFromAttr(b uint8) string {
 		if b&2 == 2 {
 			classes.WriteString("player2 ")
 		}
+		if b&(1<<6) == 1<<6 {
+			classes.WriteString("player3 ")
+		}
+		if b&(1<<7) == 1<<7 {
+			classes.WriteString("player4 ")
+		}
 		if b&(1<<2) == 1<<2 {
 			classes.WriteString("top ")
 		}
@@ -338,6 +992,12 @@ func getStrFromAttr(b uint8) string {
 		if b&2 == 2 {
 			return "<td class=\"" + classes.String() + "\">2</td>"
 		}
+		if b&(1<<6) == 1<<6 {
+			return "<td class=\"" + classes.String() + "\">3</td>"
+		}
+		if b&(1<<7) == 1<<7 {
+			return "<td class=\"" + classes.String() + "\">4</td>"
+		}
 	}
 	if classes.Len() > 0 {
 		return "<td class=\"" + classes.String() + "\"></td>"
@@ -346,166 +1006,146 @@ func getStrFromAttr(b uint8) string {
 	}
 }
 
-func defineRestrictions(first [2]uint8, second [2]uint8) (uint8, uint8) {
+func defineRestrictions(first [2]int, second [2]int) (uint8, uint8) {
 	switch first[0] - second[0] {
 	case 1:
 		return 1 << 2, 1 << 3
-	case 255:
+	case -1:
 		return 1 << 3, 1 << 2
 	case 0:
 		switch first[1] - second[1] {
 		case 1:
 			return 1 << 4, 1 << 5
-		case 255:
+		case -1:
 			return 1 << 5, 1 << 4
 		}
 	}
 	return 0, 0
 }
 
-//Генерирует препятствия для поля
-func generateBarriers(position, opponentPosition [2]uint8, count, width, height uint8) [][4][2]uint8 {
-	var res = make([][4][2]uint8, 0, count)
-	for true {
-		var y = uint8(rand.Uint32()) % height
-		var x = uint8(rand.Uint32()) % width
-		var dir = uint8(rand.Uint32()) % 8
-		newBarrier := randomBarrier(x, y, dir)
-		if !isValidObstacle(newBarrier, width, height) {
+//Генерирует препятствия для поля так, чтобы у каждого игрока из positions оставался путь до своей цели.
+//Возвращает errFieldGeneration, если работа budget закончилась раньше
+func generateBarriers(rnd *rand.Rand, positions [][2]int, count, width, height int, rules board.Variant, budget *generationBudget) ([][][2]int, error) {
+	var res = make([][][2]int, 0, count)
+	for len(res) < count {
+		if !budget.spend(len(res) + 1) {
+			return nil, errFieldGeneration
+		}
+		var row = rnd.Intn(height)
+		var column = rnd.Intn(width)
+		var dir = rnd.Intn(8)
+		newBarrier := board.RandomBarrier(row, column, dir, rules.Segments)
+		if !board.IsValidObstacle(newBarrier, width, height) {
 			continue
 		}
-		if isStepOver(newBarrier[0], newBarrier[1], res) || isStepOver(newBarrier[2], newBarrier[3], res) {
+		if board.CrossesBarriers(newBarrier, res) {
 			continue
 		}
+		if !budget.spend(pathsWork(len(positions), width, height, len(res))) {
+			return nil, errFieldGeneration
+		}
 		newSetBarriers := append(res, newBarrier)
-		if !isPathExists(position, newSetBarriers, width, height) || !isPathExists(opponentPosition, newSetBarriers, width, height) {
+		if !board.AllPathsExist(positions, newSetBarriers, width, height, rules) {
 			continue
 		}
 		res = append(res, newBarrier)
-		if uint8(len(res)) == count {
-			break
-		}
 	}
-	return res
+	return res, nil
 }
 
-//Проверяет, существует ли путь из position до противпоположного конца поля
-func isPathExists(position [2]uint8, barriers [][4][2]uint8, width, height uint8) bool {
-	var goal = func() uint8 {
-		if position[0] == 0 {
-			return height - 1
-		} else {
-			return 0
+//Генерирует центрально симметричные препятствия. Препятствия ставятся парами, поэтому нечётное количество
+//округляется вниз. Возвращает errFieldGeneration, если работа budget закончилась раньше
+func generateSymmetricBarriers(rnd *rand.Rand, positions [][2]int, count, width, height int, rules board.Variant, budget *generationBudget) ([][][2]int, error) {
+	var res = make([][][2]int, 0, count)
+	for len(res)+2 <= count {
+		if !budget.spend(2 * (len(res) + 1)) {
+			return nil, errFieldGeneration
 		}
-	}()
-	if position[0] == goal {
-		return true
-	}
-	var positions = new(PositionStack)
-	positions = nil
-	posPush(&positions, position)
-	var visitedCells = make([]bool, width*height, width*height)
-	visitedCells[position[0]*width+position[1]] = true
-	for {
-		var current, ok = posPop(&positions)
-		if !ok {
-			break
+		var row = rnd.Intn(height)
+		var column = rnd.Intn(width)
+		var dir = rnd.Intn(8)
+		newBarrier := board.RandomBarrier(row, column, dir, rules.Segments)
+		if !board.IsValidObstacle(newBarrier, width, height) {
+			continue
 		}
-		var moves = expandMoves(current, barriers, width, height, goal)
-		for _, val := range moves {
-			if val[0] == goal {
-				return true
-			}
-			if !(val[0] == current[0] && val[1] == current[1]) && !visitedCells[val[0]*width+val[1]] {
-				posPush(&positions, val)
-				visitedCells[val[0]*width+val[1]] = true
-			}
+		var mirrored = make([][2]int, len(newBarrier))
+		for i, cell := range newBarrier {
+			mirrored[i] = mirrorCell(cell, width, height)
+		}
+		if board.CrossesBarriers(newBarrier, res) {
+			continue
+		}
+		var withNew = append(res[:len(res):len(res)], newBarrier)
+		if board.CrossesBarriers(mirrored, withNew) {
+			continue
 		}
+		if !budget.spend(pathsWork(len(positions), width, height, len(res)+1)) {
+			return nil, errFieldGeneration
+		}
+		newSetBarriers := append(withNew, mirrored)
+		if !board.AllPathsExist(positions, newSetBarriers, width, height, rules) {
+			continue
+		}
+		res = append(res, newBarrier, mirrored)
 	}
-	return false
+	return res, nil
 }
 
-//Получает список доступных ходов
-func expandMoves(pos [2]uint8, barriers [][4][2]uint8, width, height, goal uint8) [][2]uint8 {
-	var res = make([][2]uint8, 0, 4)
-	var moves = func() [4][2]uint8 {
-		if goal != 0 {
-			return [4][2]uint8{
-				{pos[0] + 1, pos[1]},
-				{pos[0], pos[1] + 1},
-				{pos[0], pos[1] - 1},
-				{pos[0] - 1, pos[1]},
-			}
-		} else {
-			return [4][2]uint8{
-				{pos[0] - 1, pos[1]},
-				{pos[0], pos[1] + 1},
-				{pos[0], pos[1] - 1},
-				{pos[0] + 1, pos[1]},
-			}
+//Возвращает клетку, центрально симметричную cell
+func mirrorCell(cell [2]int, width, height int) [2]int {
+	return [2]int{height - 1 - cell[0], width - 1 - cell[1]}
+}
+
+//Проверяет ход игрока mover: previous - поле до хода, step - поле, присланное игроком. Игрок может либо
+//переместиться по правилам лобби, либо остаться на месте и поставить одно препятствие, если у него осталось
+//barriersLeft > 0. Позиции других игроков и уже стоящие препятствия менять нельзя
+func (l *Lobby) isLegalStep(previous, step Field, mover int, barriersLeft uint8) bool {
+	if step.Width != previous.Width || step.Height != previous.Height {
+		return false
+	}
+	var rules = l.Info.rules()
+	var occupied = make([][2]int, 0, len(previous.Positions)-1)
+	for i, val := range previous.Positions {
+		if i == mover {
+			continue
 		}
-	}()
-	for _, val := range moves {
-		if val[0] < height && val[1] < width && !isStepOver(pos, val, barriers) {
-			res = append(res, val)
+		if step.Positions[i] != val {
+			return false
 		}
+		occupied = append(occupied, val)
 	}
-	return res
-}
-
-//Проверяет, пересекает ли ход из from в to одно из препятствий
-func isStepOver(from, to [2]uint8, barriers [][4][2]uint8) bool {
-	for i := 0; i < len(barriers); i++ {
-		if from[0] == barriers[i][0][0] && from[1] == barriers[i][0][1] && to[0] == barriers[i][1][0] && to[1] == barriers[i][1][1] ||
-			from[0] == barriers[i][2][0] && from[1] == barriers[i][2][1] && to[0] == barriers[i][3][0] && to[1] == barriers[i][3][1] ||
-			to[0] == barriers[i][0][0] && to[1] == barriers[i][0][1] && from[0] == barriers[i][1][0] && from[1] == barriers[i][1][1] ||
-			to[0] == barriers[i][2][0] && to[1] == barriers[i][2][1] && from[0] == barriers[i][3][0] && from[1] == barriers[i][3][1] {
-			return true
+	if len(step.Barriers) < len(previous.Barriers) || len(step.Barriers) > len(previous.Barriers)+1 {
+		return false
+	}
+	for i, val := range previous.Barriers {
+		if !board.SameBarrier(step.Barriers[i], val) {
+			return false
 		}
 	}
-	return false
-}
-
-//Проверяет, ставится ли препятствие в пределах поля
-func isValidObstacle(barrier [4][2]uint8, width, height uint8) bool {
-	if barrier[0][0] < 0 || barrier[0][0] >= height || barrier[0][1] < 0 || barrier[0][1] >= width ||
-		barrier[1][0] < 0 || barrier[1][0] >= height || barrier[1][1] < 0 || barrier[1][1] >= width ||
-		barrier[2][0] < 0 || barrier[2][0] >= height || barrier[2][1] < 0 || barrier[2][1] >= width ||
-		barrier[3][0] < 0 || barrier[3][0] >= height || barrier[3][1] < 0 || barrier[3][1] >= width {
+	if len(step.Barriers) > len(previous.Barriers) {
+		return step.Positions[mover] == previous.Positions[mover] && barriersLeft > 0 &&
+			board.IsLegalBarrier(step.Barriers[len(previous.Barriers)], previous.Barriers, step.Positions, step.Width, step.Height, rules)
+	}
+	//Пропустить ход нельзя: игрок, который не сдвинулся, должен поставить препятствие
+	if step.Positions[mover] == previous.Positions[mover] {
 		return false
 	}
-	return true
-}
-
-//Генерирует случаное препятствие в точке (x,y), dir in [0,7] - одно из восьми возможных направлений
-func randomBarrier(x, y, dir uint8) [4][2]uint8 {
-	switch dir {
-	case 0:
-		return [4][2]uint8{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
-	case 1:
-		return [4][2]uint8{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}}
-	case 2:
-		return [4][2]uint8{{x, y}, {x - 1, y}, {x, y - 1}, {x - 1, y - 1}}
-	case 3:
-		return [4][2]uint8{{x, y}, {x - 1, y}, {x, y + 1}, {x - 1, y + 1}}
-	case 4:
-		return [4][2]uint8{{x, y}, {x, y + 1}, {x + 1, y}, {x + 1, y + 1}}
-	case 5:
-		return [4][2]uint8{{x, y}, {x, y - 1}, {x + 1, y}, {x + 1, y - 1}}
-	case 6:
-		return [4][2]uint8{{x, y}, {x, y + 1}, {x - 1, y}, {x - 1, y + 1}}
-	case 7:
-		return [4][2]uint8{{x, y}, {x, y - 1}, {x - 1, y}, {x - 1, y - 1}}
-	default:
-		return [4][2]uint8{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
+	for _, val := range board.StepMoves(previous.Positions[mover], occupied, previous.Barriers, previous.Width, previous.Height, rules) {
+		if val == step.Positions[mover] {
+			return true
+		}
 	}
+	return false
 }
 
 //Вызывается при получении сообщения от клиента, который находится в состоянии игры. Записывает сообщение в канал лобби
 func (l *Lobby) getTurn(str string, client *connectedClient) {
 	data := strings.TrimPrefix(str, "SOCKET STEP")
 	//fmt.Printf("Got from %s: %s\n", client.name, str)
-	l.channel <- data
+	l.channel <- playerMove{
+		player: client,
+		data:   data,
+	}
 }
 
 ////Генерирует лобби с соответствующими параметрами
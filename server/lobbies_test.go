@@ -0,0 +1,163 @@
+package server
+
+import (
+	"goServer/board"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//Препятствия широкого поля расходятся по всей его ширине, а не только по первым height столбцам
+func TestGenerateBarriersSpread(t *testing.T) {
+	const width, height, count = 40, 6, 20
+	var positions = [][2]int{{0, 20}, {height - 1, 20}}
+	var rules = board.Rules(board.ClassicVariant, false)
+	var tests = []struct {
+		name     string
+		generate func(rnd *rand.Rand, budget *generationBudget) ([][][2]int, error)
+	}{
+		{"random", func(rnd *rand.Rand, budget *generationBudget) ([][][2]int, error) {
+			return generateBarriers(rnd, positions, count, width, height, rules, budget)
+		}},
+		{"symmetric", func(rnd *rand.Rand, budget *generationBudget) ([][][2]int, error) {
+			return generateSymmetricBarriers(rnd, positions, count, width, height, rules, budget)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var budget = generationBudget(maxGenerationWork)
+			barriers, err := test.generate(rand.New(rand.NewSource(1)), &budget)
+			if err != nil {
+				t.Fatalf("can't generate barriers: %v", err)
+			}
+			if len(barriers) != count {
+				t.Fatalf("%d barriers expected, got %d", count, len(barriers))
+			}
+			var minColumn, maxColumn = width, -1
+			for _, barrier := range barriers {
+				for _, cell := range barrier {
+					if cell[0] < 0 || cell[0] >= height || cell[1] < 0 || cell[1] >= width {
+						t.Fatalf("barrier %v is outside of the %dx%d field", barrier, width, height)
+					}
+					if cell[1] < minColumn {
+						minColumn = cell[1]
+					}
+					if cell[1] > maxColumn {
+						maxColumn = cell[1]
+					}
+				}
+			}
+			if minColumn >= width/4 || maxColumn < width*3/4 {
+				t.Errorf("barriers should spread across the field, got columns %d..%d of %d", minColumn, maxColumn, width)
+			}
+		})
+	}
+}
+
+//Игрок должен либо сходить на соседнюю клетку, либо остаться на месте и поставить одно препятствие
+func TestIsLegalStep(t *testing.T) {
+	var lobby = &Lobby{Info: LobbyInfo{Variant: board.ClassicVariant}}
+	var previous = Field{Width: 5, Height: 5, Positions: [][2]int{{0, 2}, {4, 2}}, Barriers: [][][2]int{}}
+	var barrier = [][2]int{{1, 1}, {2, 1}, {1, 2}, {2, 2}}
+	var tests = []struct {
+		name         string
+		positions    [][2]int
+		barriers     [][][2]int
+		barriersLeft uint8
+		legal        bool
+	}{
+		{"move", [][2]int{{1, 2}, {4, 2}}, nil, 1, true},
+		{"move sideways", [][2]int{{0, 3}, {4, 2}}, nil, 1, true},
+		{"move two cells", [][2]int{{2, 2}, {4, 2}}, nil, 1, false},
+		{"pass", [][2]int{{0, 2}, {4, 2}}, nil, 1, false},
+		{"pass without barriers left", [][2]int{{0, 2}, {4, 2}}, nil, 0, false},
+		{"barrier", [][2]int{{0, 2}, {4, 2}}, [][][2]int{barrier}, 1, true},
+		{"barrier without barriers left", [][2]int{{0, 2}, {4, 2}}, [][][2]int{barrier}, 0, false},
+		{"move and barrier", [][2]int{{1, 2}, {4, 2}}, [][][2]int{barrier}, 1, false},
+		{"opponent moved", [][2]int{{1, 2}, {3, 2}}, nil, 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var step = previous
+			step.Positions = test.positions
+			step.Barriers = append([][][2]int{}, test.barriers...)
+			if legal := lobby.isLegalStep(previous, step, 0, test.barriersLeft); legal != test.legal {
+				t.Errorf("isLegalStep(%v, %v) = %v, want %v", test.positions, test.barriers, legal, test.legal)
+			}
+		})
+	}
+}
+
+//Поле 7x7 для тумана войны: первый игрок наверху, второй внизу, одно препятствие рядом с каждым
+var fogField = Field{
+	Width:     7,
+	Height:    7,
+	Positions: [][2]int{{0, 3}, {6, 3}},
+	Barriers:  [][][2]int{{{1, 2}, {2, 2}, {1, 3}, {2, 3}}, {{4, 2}, {5, 2}, {4, 3}, {5, 3}}},
+}
+
+func TestFiltered(t *testing.T) {
+	var tests = []struct {
+		name       string
+		player     int
+		visibility uint8
+		positions  [][2]int
+		visible    []bool
+		barriers   [][][2]int
+	}{
+		{"first player sees his barrier", 0, 2, [][2]int{{0, 3}, board.HiddenCell}, []bool{true, false}, fogField.Barriers[:1]},
+		{"second player sees his barrier", 1, 2, [][2]int{board.HiddenCell, {6, 3}}, []bool{false, true}, fogField.Barriers[1:]},
+		{"nothing near", 0, 0, [][2]int{{0, 3}, board.HiddenCell}, []bool{true, false}, [][][2]int{}},
+		{"everything visible", 0, 6, [][2]int{{0, 3}, {6, 3}}, []bool{true, true}, fogField.Barriers},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res = fogField.filtered(test.player, test.visibility)
+			if !reflect.DeepEqual(res.Positions, test.positions) || !reflect.DeepEqual(res.Visible, test.visible) {
+				t.Errorf("positions %v, visible %v, want %v, %v", res.Positions, res.Visible, test.positions, test.visible)
+			}
+			if !reflect.DeepEqual(res.Barriers, test.barriers) {
+				t.Errorf("barriers %v, want %v", res.Barriers, test.barriers)
+			}
+		})
+	}
+}
+
+func TestRevealStep(t *testing.T) {
+	var seen = fogField.filtered(0, 2)
+	var barrier = [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}}
+	var tests = []struct {
+		name      string
+		positions [][2]int
+		barriers  [][][2]int
+		err       bool
+		want      Field //Настоящее поле после хода
+	}{
+		{"move", [][2]int{{0, 4}, board.HiddenCell}, seen.Barriers, false,
+			Field{Positions: [][2]int{{0, 4}, {6, 3}}, Barriers: fogField.Barriers}},
+		{"barrier goes after hidden ones", [][2]int{{0, 3}, board.HiddenCell}, append(seen.Barriers, barrier), false,
+			Field{Positions: [][2]int{{0, 3}, {6, 3}}, Barriers: append(fogField.Barriers, barrier)}},
+		{"guessed hidden player", [][2]int{{0, 4}, {6, 3}}, seen.Barriers, true, Field{}},
+		{"visible barrier removed", [][2]int{{0, 4}, board.HiddenCell}, nil, true, Field{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var step = seen
+			step.Positions = test.positions
+			step.Barriers = test.barriers
+			res, err := revealStep(fogField, step, 0, 2)
+			if test.err {
+				if err == nil {
+					t.Errorf("error expected, got %v", res.Positions)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.Positions, test.want.Positions) || !reflect.DeepEqual(res.Barriers, test.want.Barriers) {
+				t.Errorf("positions %v, barriers %v, want %v, %v", res.Positions, res.Barriers, test.want.Positions, test.want.Barriers)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+//Форматы журнала
+const (
+	TextLogFormat = "text" //Строки вида key=value
+	JSONLogFormat = "json" //По объекту JSON на строку
+)
+
+//Создаёт журнал по настройкам: уровень debug, info, warn или error, формат text или json и файл, в который
+//пишется журнал. Если файл не указан, то журнал пишется в стандартный вывод. Уровень журнала хранится в level,
+//чтобы его можно было менять перезагрузкой настроек
+func newLogger(conf configs, level *slog.LevelVar) (*slog.Logger, error) {
+	var value slog.Level
+	if conf.LogLevel != "" {
+		if err := value.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+			return nil, err
+		}
+	}
+	var out io.Writer = os.Stdout
+	if conf.LogFile != "" {
+		file, err := os.OpenFile(conf.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+	level.Set(value)
+	var options = &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(conf.LogFormat) {
+	case JSONLogFormat:
+		return slog.New(slog.NewJSONHandler(out, options)), nil
+	default:
+		return slog.New(slog.NewTextHandler(out, options)), nil
+	}
+}
+
+//Журнал с полями клиента: его логином и адресом
+func (c *connectedClient) log() *slog.Logger {
+	var addr string
+	if c.conn != nil {
+		addr = c.conn.RemoteAddr().String()
+	}
+	return c.server.logger.With("client", c.name, "addr", addr)
+}
+
+//Журнал с полями лобби и текущей игры в нём
+func (l *Lobby) log() *slog.Logger {
+	var res = l.server.logger
+	if l.Info.ID != nil {
+		res = res.With("lobby", *l.Info.ID)
+	}
+	if l.game != "" {
+		res = res.With("game", l.game)
+	}
+	return res
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Хранилище в памяти с тем же поведением, что и у MariaDB. Нужно, чтобы запускать сервер в одном процессе с
+//проверками, без БД
+type memoryStore struct {
+	mutex     sync.Mutex
+	users     []string
+	lobbies   []LobbyInfo //Лобби в порядке ID
+	lastLobby int64       //ID последнего добавленного лобби
+	results   []GameResult
+	places    []memoryPlace
+	moveTimes []MoveTime
+	adjourned []memoryAdjourned //Отложенные игры в порядке, в котором их откладывали
+}
+
+//Место игрока в игре большего количества игроков
+type memoryPlace struct {
+	game   string
+	login  string
+	points uint8
+}
+
+//Отложенная игра
+type memoryAdjourned struct {
+	game  string
+	lobby string
+	state string
+}
+
+//Создаёт пустое хранилище в памяти
+func newMemoryStore() *memoryStore {
+	return new(memoryStore)
+}
+
+func (m *memoryStore) Migrate() error {
+	return nil
+}
+
+func (m *memoryStore) AddUser(login string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.hasUser(login) {
+		m.users = append(m.users, login)
+	}
+	return nil
+}
+
+func (m *memoryStore) hasUser(login string) bool {
+	for _, val := range m.users {
+		if val == login {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryStore) HasUser(login string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.hasUser(login), nil
+}
+
+func (m *memoryStore) DeleteUsers() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.users = nil
+	return nil
+}
+
+//Название лобби уникально, как и в таблице lobbies
+func (m *memoryStore) InsertLobby(info LobbyInfo) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, val := range m.lobbies {
+		if val.Name == info.Name {
+			return 0, errors.New("duplicate lobby name " + info.Name)
+		}
+	}
+	m.lastLobby += 1
+	var id = strconv.FormatInt(m.lastLobby, 10)
+	info.ID = &id
+	m.lobbies = append(m.lobbies, info)
+	return m.lastLobby, nil
+}
+
+func (m *memoryStore) UpsertLobby(info LobbyInfo) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, val := range m.lobbies {
+		if val.Name == info.Name {
+			if !m.hasAdjourned(*val.ID) {
+				info.ID = val.ID
+				m.lobbies[i] = info
+			}
+			return nil
+		}
+	}
+	m.lastLobby += 1
+	var id = strconv.FormatInt(m.lastLobby, 10)
+	info.ID = &id
+	m.lobbies = append(m.lobbies, info)
+	return nil
+}
+
+func (m *memoryStore) Lobby(id int) (LobbyInfo, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, val := range m.lobbies {
+		if *val.ID == strconv.Itoa(id) {
+			return val, true, nil
+		}
+	}
+	return LobbyInfo{}, false, nil
+}
+
+func (m *memoryStore) PairLobby(name, opponent string) (LobbyInfo, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, val := range m.lobbies {
+		if strings.Contains(val.Name, name) && strings.Contains(val.Name, opponent) && !m.hasAdjourned(*val.ID) {
+			return val, true, nil
+		}
+	}
+	return LobbyInfo{}, false, nil
+}
+
+//Есть ли в лобби lobby отложенные игры
+func (m *memoryStore) hasAdjourned(lobby string) bool {
+	for _, val := range m.adjourned {
+		if val.lobby == lobby {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryStore) Lobbies() ([]LobbyInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append(make([]LobbyInfo, 0, len(m.lobbies)), m.lobbies...), nil
+}
+
+func (m *memoryStore) DeleteLobby(id int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, val := range m.lobbies {
+		if *val.ID == strconv.Itoa(id) {
+			m.lobbies = append(m.lobbies[:i], m.lobbies[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteLobbies() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lobbies = nil
+	return nil
+}
+
+func (m *memoryStore) SaveResult(res GameResult) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.results = append(m.results, res)
+	return nil
+}
+
+func (m *memoryStore) SavePlace(game, login string, _, points uint8, _ string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.places = append(m.places, memoryPlace{game: game, login: login, points: points})
+	return nil
+}
+
+func (m *memoryStore) SaveMoveTime(_, login string, _ int, ms uint32, timeout bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.moveTimes = append(m.moveTimes, MoveTime{Login: login, Ms: ms, Timeout: timeout})
+	return nil
+}
+
+func (m *memoryStore) DeleteResults() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.results, m.places, m.moveTimes = nil, nil, nil
+	return nil
+}
+
+//Очки считаются так же, как в представлении stats: 3 очка за победу, 1 за ничью, в командной игре очки
+//получают и напарники. При равенстве очков участники упорядочены по логину
+func (m *memoryStore) Stats() ([]Stats, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var points = make(map[string]uint16)
+	var add = func(login string, pts uint16) {
+		if m.hasUser(login) {
+			points[login] += pts
+		}
+	}
+	for _, val := range m.results {
+		switch val.Result {
+		case "first":
+			add(val.First, 3)
+			add(val.FirstPartner, 3)
+		case "second":
+			add(val.Second, 3)
+			add(val.SecondPartner, 3)
+		case "draw":
+			for _, login := range []string{val.First, val.Second, val.FirstPartner, val.SecondPartner} {
+				add(login, 1)
+			}
+		}
+	}
+	for _, val := range m.places {
+		points[val.login] += uint16(val.points)
+	}
+	var stats = make([]Stats, 0, len(points))
+	for login, pts := range points {
+		stats = append(stats, Stats{Name: login, Points: pts})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Points != stats[j].Points {
+			return stats[i].Points > stats[j].Points
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats, nil
+}
+
+func (m *memoryStore) MoveTimes(login string) ([]MoveTime, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var res []MoveTime
+	for _, val := range m.moveTimes {
+		if login == "" || val.Login == login {
+			res = append(res, val)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Login < res[j].Login })
+	return res, nil
+}
+
+func (m *memoryStore) PairGames() ([]GameResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var res []GameResult
+	for _, val := range m.results {
+		if val.Pair != "" {
+			res = append(res, val)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Pair < res[j].Pair })
+	return res, nil
+}
+
+func (m *memoryStore) SaveAdjourned(game, lobby, state string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.adjourned = append(m.adjourned, memoryAdjourned{game: game, lobby: lobby, state: state})
+	return nil
+}
+
+func (m *memoryStore) LastAdjourned(lobby int) (string, string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := len(m.adjourned) - 1; i >= 0; i-- {
+		if m.adjourned[i].lobby == strconv.Itoa(lobby) {
+			return m.adjourned[i].game, m.adjourned[i].state, true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+func (m *memoryStore) DeleteAdjourned(game string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, val := range m.adjourned {
+		if val.game == game {
+			m.adjourned = append(m.adjourned[:i], m.adjourned[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
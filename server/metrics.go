@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Границы корзин гистограмм в секундах
+var (
+	moveBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	dbBuckets   = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+//Все метрики сервера, которые отдаются Prometheus в текстовом формате по адресу /metrics. Количество клиентов
+//и лобби не хранится, а считается при каждом запросе
+type serverMetrics struct {
+	gamesStarted   atomic.Uint64
+	gamesFinished  *counterVec //По причине окончания игры
+	gamesForfeited *counterVec //Проигрыши из-за времени, формата или недопустимого хода, по причине
+	protocolErrors *counterVec //По виду ошибки
+	connsDropped   *counterVec //Отказы в подключении и принудительные отключения, по причине
+	bytesIn        atomic.Uint64
+	bytesOut       atomic.Uint64
+	moveLatency    *histogram    //Время обдумывания хода игроком
+	dbLatency      *histogramVec //Время запросов к БД по виду запроса
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		gamesFinished:  newCounterVec(),
+		gamesForfeited: newCounterVec(),
+		protocolErrors: newCounterVec(),
+		connsDropped:   newCounterVec(),
+		moveLatency:    newHistogram(moveBuckets),
+		dbLatency:      newHistogramVec(dbBuckets),
+	}
+}
+
+//Учитывает окончание игры с причиной reason
+func (m *serverMetrics) gameFinished(reason string) {
+	m.gamesFinished.inc(reason)
+	switch reason {
+	case ReasonTime, ReasonFormat, ReasonIllegal:
+		m.gamesForfeited.inc(reason)
+	}
+}
+
+//Счётчики с одной меткой
+type counterVec struct {
+	mutex  sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: map[string]uint64{}}
+}
+
+func (c *counterVec) inc(label string) {
+	c.mutex.Lock()
+	c.values[label] += 1
+	c.mutex.Unlock()
+}
+
+//Пишет счётчики в формате Prometheus с меткой label
+func (c *counterVec) write(str *strings.Builder, name, help, label string) {
+	str.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s counter\n", name, help, name))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		str.WriteString(fmt.Sprintf("%s{%s=%q} %d\n", name, label, key, c.values[key]))
+	}
+}
+
+//Гистограмма с накопительными корзинами, как в Prometheus
+type histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64 //counts[i] - количество значений не больше buckets[i]
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	var val = d.Seconds()
+	h.mutex.Lock()
+	for i, bound := range h.buckets {
+		if val <= bound {
+			h.counts[i] += 1
+		}
+	}
+	h.count += 1
+	h.sum += val
+	h.mutex.Unlock()
+}
+
+//Пишет корзины, сумму и количество значений гистограммы. labels - уже отформатированные метки без скобок
+func (h *histogram) write(str *strings.Builder, name, labels string) {
+	var prefix = labels
+	if prefix != "" {
+		prefix += ","
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.buckets {
+		str.WriteString(fmt.Sprintf("%s_bucket{%sle=\"%g\"} %d\n", name, prefix, bound, h.counts[i]))
+	}
+	str.WriteString(fmt.Sprintf("%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count))
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	str.WriteString(fmt.Sprintf("%s_sum%s %g\n", name, labels, h.sum))
+	str.WriteString(fmt.Sprintf("%s_count%s %d\n", name, labels, h.count))
+}
+
+//Гистограммы с одной меткой
+type histogramVec struct {
+	mutex   sync.Mutex
+	buckets []float64
+	values  map[string]*histogram
+}
+
+func newHistogramVec(buckets []float64) *histogramVec {
+	return &histogramVec{buckets: buckets, values: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(label string, d time.Duration) {
+	h.mutex.Lock()
+	var val, ok = h.values[label]
+	if !ok {
+		val = newHistogram(h.buckets)
+		h.values[label] = val
+	}
+	h.mutex.Unlock()
+	val.observe(d)
+}
+
+func (h *histogramVec) write(str *strings.Builder, name, help, label string) {
+	str.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s histogram\n", name, help, name))
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		h.values[key].write(str, name, fmt.Sprintf("%s=%q", label, key))
+	}
+}
+
+//Ключи словаря по порядку, чтобы метрики выводились всегда одинаково
+func sortedKeys[T any](m map[string]T) []string {
+	var res = make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+//Пишет метрику-значение одной строкой
+func writeGauge(str *strings.Builder, name, kind, help string, val uint64) {
+	str.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, val))
+}
+
+//Отдаёт все метрики в текстовом формате Prometheus
+func (s *server) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	var str strings.Builder
+	s.clientsMapMutex.Lock()
+	var clients = len(s.connectedClient)
+	s.clientsMapMutex.Unlock()
+	s.lobbiesMutex.Lock()
+	var lobbies, playing = len(s.playingLobbies), 0
+	for _, val := range s.playingLobbies {
+		if val != nil && val.isPlaying {
+			playing += 1
+		}
+	}
+	s.lobbiesMutex.Unlock()
+	writeGauge(&str, "goserver_connected_clients", "gauge", "Connected clients", uint64(clients))
+	writeGauge(&str, "goserver_active_lobbies", "gauge", "Lobbies with players waiting or playing", uint64(lobbies))
+	writeGauge(&str, "goserver_active_games", "gauge", "Games in progress", uint64(playing))
+	writeGauge(&str, "goserver_games_started_total", "counter", "Games started", s.metrics.gamesStarted.Load())
+	s.metrics.gamesFinished.write(&str, "goserver_games_finished_total", "Games finished by reason", "reason")
+	s.metrics.gamesForfeited.write(&str, "goserver_games_forfeited_total", "Games lost by time, format or illegal move", "reason")
+	s.metrics.protocolErrors.write(&str, "goserver_protocol_errors_total", "Malformed or unexpected client messages by kind", "kind")
+	s.metrics.connsDropped.write(&str, "goserver_connections_dropped_total", "Connections refused or dropped by limits by reason", "reason")
+	writeGauge(&str, "goserver_received_bytes_total", "counter", "Bytes received from clients", s.metrics.bytesIn.Load())
+	writeGauge(&str, "goserver_sent_bytes_total", "counter", "Bytes sent to clients", s.metrics.bytesOut.Load())
+	str.WriteString("# HELP goserver_move_duration_seconds Time players spend on a move\n# TYPE goserver_move_duration_seconds histogram\n")
+	s.metrics.moveLatency.write(&str, "goserver_move_duration_seconds", "")
+	s.metrics.dbLatency.write(&str, "goserver_db_query_duration_seconds", "Database query latency by statement", "statement")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(str.String()))
+}
+
+//Запускает HTTP-сервер с метриками на адресе из настроек, если он указан
+func (s *server) startMetrics() {
+	if s.Configs.MetricsAddress == "" {
+		return
+	}
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	go func() {
+		s.logger.Info("metrics endpoint started", "address", s.Configs.MetricsAddress)
+		if err := http.ListenAndServe(s.Configs.MetricsAddress, mux); err != nil {
+			s.logger.Error("metrics endpoint stopped", "err", err)
+		}
+	}()
+}
+
+//Вид запроса к БД для метрик: первое слово запроса
+func statementKind(query string) string {
+	var fields = strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
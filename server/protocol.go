@@ -0,0 +1,23 @@
+package server
+
+//Файл со спецификацией протокола по умолчанию: JSON Schema всех команд, их данных, ответов и событий.
+//Относительный путь отсчитывается от каталога файла настроек
+const DefaultProtocolPath = "protocol.schema.json"
+
+//Проверяет по спецификации протокола данные payload команды command. Неизвестные команды не проверяются
+func (s *server) checkPayload(command, payload string) error {
+	var spec, ok = s.protocol.Commands[command]
+	if !ok {
+		return nil
+	}
+	if payload == "" {
+		if spec.Payload == "required" {
+			return newError(ErrBadRequest, command+" requires JSON data")
+		}
+		return nil
+	}
+	if err := s.protocol.Validate(spec.Request, []byte(payload)); err != nil {
+		return newError(ErrBadRequest, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Классы команд, частота которых ограничивается отдельно
+const (
+	QueryCommands = "query" //Запросы на чтение, каждый из которых обращается к БД
+	LobbyCommands = "lobby" //Вход в лобби и выход из него
+	WriteCommands = "write" //Создание лобби
+	LoginCommands = "login" //Вход на сервер
+	OtherCommands = "other" //Все остальные строки: неизвестные команды, мусор и ходы вне игры
+)
+
+//Команды каждого класса. Не перечисленные здесь строки относятся к классу other, кроме DISCONNECT и ходов
+//играющего клиента, которые частотой не ограничиваются
+var commandClasses = map[string][]string{
+	QueryCommands: {"GET LOBBY", "GET RANDOMLOBBY", "GET STATS", "GET PAIRS", "GET PLAYERSTATS", "GET FIELD"},
+	LobbyCommands: {"SOCKET JOINLOBBY", "SOCKET LEAVELOBBY"},
+	WriteCommands: {"POST LOBBY"},
+	LoginCommands: {"CONNECTION"},
+	OtherCommands: nil,
+}
+
+//Ограничение частоты запросов: в среднем rate запросов в секунду, но не больше burst подряд
+type rateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst uint    `json:"burst"`
+}
+
+//Корзина токенов. Каждый запрос забирает токен, токены возвращаются со скоростью rate, но их не бывает больше
+//burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//Забирает токен, если он есть. Иначе возвращает false и время, через которое токен появится
+func (b *tokenBucket) take(limit rateLimit, now time.Time) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+//Ограничитель частоты запросов одного соединения или одного пользователя
+type rateLimiter struct {
+	mutex      sync.Mutex
+	buckets    map[string]*tokenBucket //По классу команд
+	violations tokenBucket             //Сколько ещё отклонённых запросов простится клиенту
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+//Забирает токен класса class
+func (r *rateLimiter) take(class string, limit rateLimit, now time.Time) (bool, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var bucket, ok = r.buckets[class]
+	if !ok {
+		bucket = new(tokenBucket)
+		r.buckets[class] = bucket
+	}
+	return bucket.take(limit, now)
+}
+
+//Возвращает токен класса class, забранный зря
+func (r *rateLimiter) refund(class string) {
+	r.mutex.Lock()
+	r.buckets[class].tokens += 1
+	r.mutex.Unlock()
+}
+
+//Учитывает отклонённый запрос. Возвращает false, если клиент превысил limit отклонённых запросов в минуту
+func (r *rateLimiter) violate(limit uint, now time.Time) bool {
+	if limit == 0 {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ok, _ := r.violations.take(rateLimit{Rate: float64(limit) / 60, Burst: limit}, now)
+	return ok
+}
+
+//Класс команды command. command - пустая строка, если в строке не нашлось команды
+func commandClass(command string) string {
+	for class, commands := range commandClasses {
+		for _, val := range commands {
+			if val == command {
+				return class
+			}
+		}
+	}
+	return OtherCommands
+}
+
+//Является ли command ходом: SOCKET STEP или ход без команды
+func isMove(command string) bool {
+	return command == "SOCKET STEP" || strings.HasPrefix(command, "{")
+}
+
+//Ограничитель частоты запросов пользователя name, общий для всех его соединений
+func (s *server) userLimiter(name string) *rateLimiter {
+	s.userLimitsMutex.Lock()
+	defer s.userLimitsMutex.Unlock()
+	var res, ok = s.userLimiters[name]
+	if !ok {
+		res = newRateLimiter()
+		s.userLimiters[name] = res
+	}
+	return res
+}
+
+//Проверяет, не превысил ли клиент ограничение частоты для команды command, по соединению и, если он вошёл,
+//по пользователю. Если превысил, то отправляет ему отказ и возвращает true. Слишком часто превышающий
+//ограничения клиент отключается
+func (s *server) throttle(c *connectedClient, command string) bool {
+	//Ходы играющего клиента ограничивает сама игра, а отключиться можно всегда
+	if command == "DISCONNECT" || isMove(command) && s.isPlaying(c) {
+		return false
+	}
+	var class = commandClass(command)
+	s.configsMutex.Lock()
+	var limit, limited = s.Configs.RateLimits[class]
+	var violations = s.Configs.RateViolations
+	s.configsMutex.Unlock()
+	if !limited {
+		return false
+	}
+	var now = s.clock.Now()
+	var ok, wait = c.limiter.take(class, limit, now)
+	if ok && c.name != "" {
+		if ok, wait = s.userLimiter(c.name).take(class, limit, now); !ok {
+			c.limiter.refund(class)
+		}
+	}
+	if ok {
+		return false
+	}
+	c.log().Warn("request throttled", "command", command, "class", class)
+	s.metrics.protocolErrors.inc("throttled")
+	if !c.limiter.violate(violations, now) {
+		c.log().Warn("too many throttled requests, disconnecting")
+		s.metrics.connsDropped.inc(DropRateLimit)
+		s.disconnect(c)
+		return true
+	}
+	var message = "too many " + command + " requests"
+	if class == OtherCommands {
+		message = "too many unrecognized requests or moves outside of a game"
+	}
+	c.sendErrorInfo(ErrorInfo{
+		Code:       ErrThrottled,
+		Message:    message,
+		RetryAfter: uint32(wait.Milliseconds()),
+	})
+	return true
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	var limit = rateLimit{Rate: 1, Burst: 2}
+	var start = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var steps = []struct {
+		at   time.Duration //Время запроса от start
+		ok   bool
+		wait time.Duration
+	}{
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, time.Second},
+		{500 * time.Millisecond, false, 500 * time.Millisecond},
+		{time.Second, true, 0},
+		{time.Minute, true, 0},
+		{time.Minute, true, 0},
+		{time.Minute, false, time.Second},
+	}
+	var bucket tokenBucket
+	for i, step := range steps {
+		ok, wait := bucket.take(limit, start.Add(step.at))
+		if ok != step.ok || wait != step.wait {
+			t.Errorf("request %d at %v: take = %v, %v, want %v, %v", i, step.at, ok, wait, step.ok, step.wait)
+		}
+	}
+}
+
+func TestCommandClass(t *testing.T) {
+	var tests = []struct {
+		command string
+		class   string
+	}{
+		{"GET STATS", QueryCommands},
+		{"SOCKET JOINLOBBY", LobbyCommands},
+		{"POST LOBBY", WriteCommands},
+		{"CONNECTION", LoginCommands},
+		{"SOCKET STEP", OtherCommands},
+		{"HELLO", OtherCommands},
+		{"", OtherCommands},
+	}
+	for _, test := range tests {
+		if class := commandClass(test.command); class != test.class {
+			t.Errorf("commandClass(%q) = %q, want %q", test.command, class, test.class)
+		}
+	}
+}
+
+//Запросы сверх ограничения получают THROTTLED, а клиент, который слишком часто превышает ограничения,
+//отключается
+func TestThrottle(t *testing.T) {
+	var tests = []struct {
+		name       string
+		limits     map[string]rateLimit
+		violations uint
+		command    string
+		replies    []string //Код ошибки в ответе на каждый запрос, пустой - если запрос выполнен
+		retryAfter uint32   //retryAfter последнего ответа
+	}{
+		{"query", map[string]rateLimit{QueryCommands: {Rate: 1, Burst: 2}}, 0, "GET STATS",
+			[]string{"", "", ErrThrottled}, 1000},
+		{"other class", map[string]rateLimit{OtherCommands: {Rate: 0.5, Burst: 1}}, 0, "HELLO",
+			[]string{ErrUnknownCommand, ErrThrottled}, 2000},
+		{"moves outside of a game", map[string]rateLimit{OtherCommands: {Rate: 1, Burst: 1}}, 0, "SOCKET STEP",
+			[]string{ErrNotPlaying, ErrThrottled}, 1000},
+		{"class without a limit", map[string]rateLimit{WriteCommands: {Rate: 1, Burst: 1}}, 0, "GET STATS",
+			[]string{"", "", ""}, 0},
+		{"too many violations", map[string]rateLimit{QueryCommands: {Rate: 1, Burst: 1}}, 1, "GET STATS",
+			[]string{"", ErrThrottled, "disconnect"}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var h = startHarness(t)
+			h.server.configsMutex.Lock()
+			h.server.Configs.RateLimits = test.limits
+			h.server.Configs.RateViolations = test.violations
+			h.server.configsMutex.Unlock()
+			c, err := h.Connect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			var response ErrorResponse
+			for i, want := range test.replies {
+				reply, err := c.Command(test.command, "")
+				if want == "disconnect" {
+					if err == nil && reply != `{"MESSAGE":"BYE"}` {
+						t.Fatalf("request %d: disconnect expected, got %s", i, reply)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+				response = ErrorResponse{}
+				_ = json.Unmarshal([]byte(reply), &response)
+				if response.Error.Code != want {
+					t.Fatalf("request %d: error %q expected, got %s", i, want, reply)
+				}
+			}
+			if response.Error.RetryAfter != test.retryAfter {
+				t.Errorf("retryAfter %d expected, got %d", test.retryAfter, response.Error.RetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+//Как часто проверять, не изменились ли файлы настроек и участников
+const ReloadCheckInterval = 2 * time.Second
+
+//Настройки, которые можно менять без перезапуска сервера. Ограничения игры действуют на игры, которые начнутся
+//после перезагрузки, параметры лобби - на лобби новых участников
+var liveSettings = map[string]bool{
+	"timeout":             true,
+	"max_turns":           true,
+	"repetitionLimit":     true,
+	"noProgressTurns":     true,
+	"timeControl":         true,
+	"timeBank":            true,
+	"increment":           true,
+	"delay":               true,
+	"seed":                true,
+	"generator":           true,
+	"fairnessTolerance":   true,
+	"jumps":               true,
+	"variant":             true,
+	"visibility":          true,
+	"logLevel":            true,
+	"shutdownTimeout":     true,
+	"maxConnections":      true,
+	"maxConnectionsPerIP": true,
+	"loginTimeout":        true,
+	"rateLimits":          true,
+	"rateViolations":      true,
+}
+
+//Перезагружает настройки по SIGHUP
+func (s *server) handleReload() {
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		s.logger.Info("got SIGHUP, reloading configuration")
+		s.reload()
+	}
+}
+
+//Раз в ReloadCheckInterval проверяет время изменения файлов настроек и участников и перезагружает их, если
+//оно изменилось
+func (s *server) watchConfigs() {
+	var modified = func() [2]time.Time {
+		var res [2]time.Time
+		for i, path := range []string{s.configPath, ParticipantsPath} {
+			if info, err := os.Stat(path); err == nil {
+				res[i] = info.ModTime()
+			}
+		}
+		return res
+	}
+	var last = modified()
+	for s.active.Load() {
+		time.Sleep(ReloadCheckInterval)
+		if current := modified(); current != last {
+			last = current
+			s.logger.Info("configuration files changed, reloading")
+			s.reload()
+		}
+	}
+}
+
+//Перечитывает файл настроек и список участников и применяет то, что можно применить на лету. Лобби новых
+//участников создаются без блокировки настроек, чтобы подключения не ждали генерации полей
+func (s *server) reload() {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+	s.configsMutex.Lock()
+	s.reloadConfigs()
+	var conf = s.Configs
+	var competitors = append([]string{}, s.competitors...)
+	s.configsMutex.Unlock()
+	s.reloadParticipants(conf, competitors)
+}
+
+//Перечитывает файл настроек. Изменения из liveSettings применяются, об остальных пишется в журнал. Если
+//новые настройки неверны, то остаются старые
+func (s *server) reloadConfigs() {
+	conf, err := loadConfigs(s.configPath)
+	if err == nil {
+		//Логин и пароль, введённые в консоли, повторно не спрашиваются
+		if !conf.LoginFromFile {
+			conf.DbLogin, conf.DbPassword = s.Configs.DbLogin, s.Configs.DbPassword
+		}
+		err = conf.validate()
+	}
+	if err != nil {
+		s.logger.Error("can't reload configuration, keeping the current one", "err", err)
+		return
+	}
+	var applied, ignored []string
+	var current = reflect.ValueOf(&s.Configs).Elem()
+	var updated = reflect.ValueOf(conf)
+	for i := 0; i < current.NumField(); i++ {
+		if reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+		var name = settingName(current.Type().Field(i))
+		if liveSettings[name] {
+			current.Field(i).Set(updated.Field(i))
+			applied = append(applied, name)
+		} else {
+			ignored = append(ignored, name)
+		}
+	}
+	s.setLimits(newGameLimits(s.Configs))
+	var level = s.logLevel.Level()
+	if s.Configs.LogLevel != "" {
+		_ = level.UnmarshalText([]byte(s.Configs.LogLevel))
+	}
+	s.logLevel.Set(level)
+	if len(applied) > 0 {
+		s.logger.Info("configuration reloaded", "applied", applied)
+	}
+	if len(ignored) > 0 {
+		s.logger.Warn("settings can't be changed without restart", "settings", ignored)
+	}
+}
+
+//Перечитывает список участников. Новые участники добавляются в таблицу user, для их игр со всеми остальными
+//участниками competitors по настройкам conf создаются лобби, и эти игры добавляются в конец расписания. Лобби
+//создаются до того, как участники попадут в расписание, поэтому под configsMutex меняется только расписание.
+//Сыгранные игры и уже созданные лобби не меняются. Удалить участника на лету нельзя, об этом пишется в журнал
+func (s *server) reloadParticipants(conf configs, competitors []string) {
+	list, err := s.participants()
+	if err != nil {
+		s.logger.Error("can't reload participants list", "err", err)
+		return
+	}
+	var known = make(map[string]bool, len(competitors))
+	for _, val := range competitors {
+		known[val] = true
+	}
+	var listed = make(map[string]bool, len(list))
+	var added []string
+	for _, val := range list {
+		if !known[val] && !listed[val] {
+			added = append(added, val)
+		}
+		listed[val] = true
+	}
+	var removed []string
+	for _, val := range competitors {
+		if !listed[val] {
+			removed = append(removed, val)
+		}
+	}
+	if len(removed) > 0 {
+		s.logger.Warn("participants can't be removed without restart", "participants", removed)
+	}
+	if len(added) == 0 {
+		return
+	}
+	var opponents = competitors
+	for _, name := range added {
+		s.addUser(name)
+		for _, opponent := range opponents {
+			s.createPairLobbies(conf, opponent, name)
+		}
+		opponents = append(opponents, name)
+	}
+	s.configsMutex.Lock()
+	s.scheduleMutex.Lock()
+	opponents = competitors
+	for _, name := range added {
+		for _, opponent := range opponents {
+			for k := uint(0); k < s.gamesToPlay; k++ {
+				s.schedule[opponent] = append(s.schedule[opponent], name)
+				s.schedule[name] = append(s.schedule[name], opponent)
+			}
+		}
+		opponents = append(opponents, name)
+	}
+	s.competitors = append(s.competitors, added...)
+	s.scheduleMutex.Unlock()
+	s.configsMutex.Unlock()
+	s.logger.Info("participants added", "participants", added)
+}
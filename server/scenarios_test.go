@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"goServer/client"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//Участники турнира в проверках
+var testParticipants = []string{"alice", "bob"}
+
+//Запускает сервер для одной проверки и останавливает его в конце проверки
+func startHarness(t *testing.T) *harness {
+	t.Helper()
+	h, err := newHarness(harnessOptions{Participants: testParticipants, Seed: 1})
+	if err != nil {
+		t.Fatalf("can't start the server: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+//Входит под логинами всех участников
+func loginAll(t *testing.T, h *harness) [2]*fakeClient {
+	t.Helper()
+	var res [2]*fakeClient
+	for i, name := range testParticipants {
+		c, err := h.Login(name)
+		if err != nil {
+			t.Fatalf("%s can't log in: %v", name, err)
+		}
+		t.Cleanup(c.Close)
+		res[i] = c
+	}
+	return res
+}
+
+//Входит в лобби: по расписанию, если id пустой, или в лобби id
+func join(t *testing.T, c *fakeClient, id string) {
+	t.Helper()
+	var payload = `{"id":null}`
+	if id != "" {
+		payload = fmt.Sprintf(`{"id":%q}`, id)
+	}
+	reply, err := c.Command("SOCKET JOINLOBBY", payload)
+	if err != nil {
+		t.Fatalf("%s can't join lobby: %v", c.Name, err)
+	}
+	var res client.JoinLobbyResponse
+	if err = json.Unmarshal([]byte(reply), &res); err != nil || !res.Success {
+		t.Fatalf("%s can't join lobby: %s", c.Name, reply)
+	}
+}
+
+//Играет по расписанию первую игру участников, оба ходят по кратчайшему пути
+func playScheduled(t *testing.T, h *harness) gameRecord {
+	t.Helper()
+	var players = loginAll(t, h)
+	for _, val := range players {
+		join(t, val, "")
+	}
+	record, err := h.Play(players, client.ShortestPathStrategy)
+	if err != nil {
+		t.Fatalf("game failed: %v", err)
+	}
+	return record
+}
+
+//Игра по расписанию заканчивается тем, что кто-то дошёл до цели, победитель получает 3 очка, а лобби
+//удаляется
+func TestScheduledGame(t *testing.T) {
+	var h = startHarness(t)
+	var record = playScheduled(t, h)
+	var winner = ""
+	for i, val := range record.Ends {
+		if val.Reason != ReasonGoal {
+			t.Errorf("%s: game finished by %s, goal expected", testParticipants[i], val.Reason)
+		}
+		if val.Result == "win" {
+			winner = testParticipants[i]
+		}
+	}
+	if winner == "" || record.Ends[0].Result == record.Ends[1].Result {
+		t.Fatalf("one winner expected, got %s and %s", record.Ends[0].Result, record.Ends[1].Result)
+	}
+	stats, err := h.Store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Name != winner || stats[0].Points != 3 {
+		t.Errorf("3 points of %s expected, got %v", winner, stats)
+	}
+	lobbies, err := h.Store.Lobbies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lobbies) != 0 {
+		t.Errorf("lobby of the finished game wasn't deleted, %d lobbies left", len(lobbies))
+	}
+}
+
+//Два сервера с одним зерном проводят одинаковые игры
+func TestSameSeedSameGame(t *testing.T) {
+	var records [2]gameRecord
+	for i := range records {
+		var h = startHarness(t)
+		records[i] = playScheduled(t, h)
+		h.Close()
+	}
+	if !reflect.DeepEqual(records[0], records[1]) {
+		t.Errorf("games differ:\n%v\n%v", records[0].Moves, records[1].Moves)
+	}
+}
+
+//Игрок, который не ответил за время хода, проигрывает по времени
+func TestMoveTimeout(t *testing.T) {
+	var h = startHarness(t)
+	var players = loginAll(t, h)
+	reply, err := players[0].Command("POST LOBBY", `{"width":5,"height":5,"gameBarrierCount":2,"playerBarrierCount":2,"players_count":2,"name":"timeout"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lobby LobbyID
+	if err = json.Unmarshal([]byte(reply), &lobby); err != nil || lobby.ID == nil {
+		t.Fatalf("can't create lobby: %s", reply)
+	}
+	for _, val := range players {
+		join(t, val, *lobby.ID)
+	}
+	_, mover, err := h.StartGame(players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Clock.WaitTimers(1, 5*time.Second) {
+		t.Fatal("server isn't waiting for a move")
+	}
+	h.Clock.Advance(time.Hour)
+	for i, val := range players {
+		payload, err := val.Expect("SOCKET ENDGAME")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var end client.EndGame
+		_ = json.Unmarshal([]byte(payload), &end)
+		var result = "win"
+		if i == mover {
+			result = "lose"
+		}
+		if end.Reason != ReasonTime || end.Result != result {
+			t.Errorf("%s: %s by time expected, got %s", val.Name, result, payload)
+		}
+	}
+}
+
+//Лобби расписания с отложенной игрой не меняется, когда расписание создаётся заново с другим зерном, иначе
+//игра продолжилась бы на другом поле
+func TestScheduleKeepsAdjournedLobby(t *testing.T) {
+	var h = startHarness(t)
+	var players = loginAll(t, h)
+	for _, val := range players {
+		join(t, val, "")
+	}
+	if _, _, err := h.StartGame(players); err != nil {
+		t.Fatal(err)
+	}
+	lobbies, err := h.Store.Lobbies()
+	if err != nil || len(lobbies) != 1 {
+		t.Fatalf("one lobby expected, got %v, %v", lobbies, err)
+	}
+	var before = lobbies[0]
+	var id, _ = strconv.Atoi(*before.ID)
+	if err = h.server.adjournLobby(id); err != nil {
+		t.Fatal(err)
+	}
+	h.WaitGames()
+	h.server.configsMutex.Lock()
+	h.server.Configs.Seed = 2
+	h.server.configsMutex.Unlock()
+	h.server.createPairLobbies(h.server.Configs, testParticipants[0], testParticipants[1])
+	after, found, err := h.Store.Lobby(id)
+	if err != nil || !found {
+		t.Fatalf("lobby %d expected, got %v", id, err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("lobby with an adjourned game changed:\n%+v\n%+v", before, after)
+	}
+}
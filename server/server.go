@@ -3,41 +3,66 @@ package server
 import (
 	"bufio"
 	"bytes"
-	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"goServer/board"
+	"goServer/schema"
+	"hash/fnv"
+	"io"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-//Максимальное количество подключенных клиентов
+//Наибольшая длина REQ_ID в байтах JSON
+const MaxRequestIDLength = 64
+
+//Максимальное количество подключенных клиентов, если maxConnections не задан в настройках
 const MaxPlayers = 24
 
-var Server = initServer()
+//Сколько ждать перед началом игры, когда лобби заполнилось
+const GameStartDelay = time.Second
 
-//Структура, отвечающая за сервер. Не создавать больше одного
+//Структура, отвечающая за сервер. Журнал, метрики и спецификация протокола у каждого сервера свои
 type server struct {
 	listener        net.Listener
 	connectedClient map[*connectedClient]*Lobby
 	clientsMapMutex sync.Mutex
 	playingLobbies  map[uint]*Lobby
 	lobbiesMutex    sync.Mutex
-	db              *sql.DB
+	store           Store
+	clock           Clock
+	rand            *rand.Rand               //Случайные числа для лобби и порядка ходов
+	participants    func() ([]string, error) //Откуда берётся список участников
 	competitors     []string
 	schedule        map[string][]string
 	scheduleMutex   sync.Mutex
-	port            uint
-	active          bool
+	active          atomic.Bool //Сбрасывается, когда сервер останавливается
 	gamesToPlay     uint
 	Configs         configs
+	configsMutex    sync.Mutex              //Защищает Configs и список участников при перезагрузке настроек
+	reloadMutex     sync.Mutex              //Не даёт двум перезагрузкам настроек идти одновременно
+	configPath      string                  //Файл, из которого прочитаны настройки
+	userLimiters    map[string]*rateLimiter //Ограничители частоты запросов пользователей, общие для всех их соединений
+	userLimitsMutex sync.Mutex
+	limits          gameLimits //Ограничения для новых игр
+	limitsMutex     sync.Mutex
+	fields          map[fieldKey]Field //Сгенерированные поля лобби
+	fieldsMutex     sync.Mutex
+	games           sync.WaitGroup //Идущие игры, которых ждёт остановка сервера
+	stopOnce        sync.Once
+	logger          *slog.Logger
+	logLevel        *slog.LevelVar //Уровень журнала, который меняется перезагрузкой настроек
+	metrics         *serverMetrics
+	protocol        *schema.Spec //Спецификация протокола, по которой проверяются данные команд и ходы
 }
 
 //Структура с настройками сервера
@@ -62,159 +87,417 @@ type configs struct {
 	Timeout time.Duration `json:"timeout"`
 	//Максимальное количество ходов в игре, после чего будет объясвлена ничья
 	MaxTurns int `json:"max_turns"`
+	//Сколько раз должна повториться позиция, чтобы была объявлена ничья. Если 0, то повторения не проверяются
+	RepetitionLimit int `json:"repetitionLimit"`
+	//Через сколько ходов без изменения кратчайших путей игроков объявляется ничья. Если 0, то правило выключено
+	NoProgressTurns int `json:"noProgressTurns"`
+	//Контроль времени для создаваемых лобби: fischer или bronstein
+	TimeControl string `json:"timeControl"`
+	//Банк времени каждого игрока в миллисекундах. Если 0, то каждый ход ограничен timeout
+	TimeBank uint32 `json:"timeBank"`
+	//Добавка Фишера в миллисекундах
+	Increment uint32 `json:"increment"`
+	//Задержка Бронштейна в миллисекундах
+	Delay uint32 `json:"delay"`
+	//Зерно, из которого создаются лобби турнира. Если 0, то лобби каждый раз получаются разными
+	Seed int64 `json:"seed"`
+	//Режим генерации поля для создаваемых лобби: random, balanced или symmetric
+	Generator string `json:"generator"`
+	//Наибольшая разница кратчайших путей игроков в режиме balanced
+	FairnessTolerance uint8 `json:"fairnessTolerance"`
+	//Если true, то каждое поле играется дважды, и во второй игре игроки меняются первым ходом и стороной поля.
+	//Тогда gamesToPlay - это количество таких пар игр
+	PairedGames bool `json:"pairedGames"`
+	//Разрешены ли в создаваемых лобби прыжки через стоящего рядом противника
+	Jumps bool `json:"jumps"`
+	//Вариант правил для создаваемых лобби: classic, jumps, long_barriers, torus, diagonal или fog
+	Variant string `json:"variant"`
+	//Радиус видимости в варианте fog. Если 0, то используется радиус по умолчанию
+	Visibility uint8 `json:"visibility"`
+	//Уровень журнала: debug, info, warn или error. По умолчанию info
+	LogLevel string `json:"logLevel"`
+	//Формат журнала: text или json
+	LogFormat string `json:"logFormat"`
+	//Файл, в который пишется журнал. Если не указан, то журнал пишется в стандартный вывод
+	LogFile string `json:"logFile"`
+	//Адрес HTTP-сервера с метриками Prometheus, например ":9100". Если не указан, то метрики не отдаются
+	MetricsAddress string `json:"metricsAddress"`
+	//Сколько секунд при остановке сервера ждать окончания идущих игр, прежде чем отложить их
+	ShutdownTimeout uint `json:"shutdownTimeout"`
+	//Наибольшее количество одновременных подключений. Если 0, то MaxPlayers
+	MaxConnections uint `json:"maxConnections"`
+	//Наибольшее количество одновременных подключений с одного IP-адреса. Если 0, то не ограничено
+	MaxConnectionsPerIP uint `json:"maxConnectionsPerIP"`
+	//Сколько секунд клиент может не входить после подключения, прежде чем его отключат
+	LoginTimeout uint `json:"loginTimeout"`
+	//Ограничения частоты запросов по классам команд: query, lobby, write, login и other, к которому относятся все
+	//остальные строки. Команды класса, для которого ограничение не задано, не ограничиваются
+	RateLimits map[string]rateLimit `json:"rateLimits"`
+	//Сколько отклонённых запросов в минуту прощается клиенту, прежде чем его отключат. Если 0, то не отключают
+	RateViolations uint `json:"rateViolations"`
+	//Файл со спецификацией протокола. Относительный путь отсчитывается от каталога файла настроек, по умолчанию
+	//protocol.schema.json рядом с ним
+	ProtocolPath string `json:"protocolPath"`
 }
 
-//Создаёт экземпляр сервера
-func initServer() *server {
-	var res = new(server)
-	conf := readConfigs()
-	res.listener, _ = net.Listen("tcp4", ":"+strconv.Itoa(int(conf.ServerPort)))
-	var credits = fmt.Sprintf("%s:%s@/%s", conf.DbLogin, conf.DbPassword, conf.DbName)
-	db, err := sql.Open("mysql", credits)
+//Команды администратора, которые принимают аргумент
+var argCommands = []string{"field", "adjourn", "resume"}
+
+//Создаёт сервер с настройками из файла и переменных окружения, который слушает serverPort и хранит данные
+//в MariaDB. Возвращает ошибку, если настройки или спецификация протокола неверны или порт занят
+func NewServer() (*server, error) {
+	conf, path, err := readConfigs()
+	if err != nil {
+		return nil, err
+	}
+	var level = new(slog.LevelVar)
+	log, err := newLogger(conf, level)
+	if err != nil {
+		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+		log.Error("can't configure logging, using defaults", "err", err)
+	}
+	var protocolPath = conf.protocolPath(path)
+	spec, err := schema.Load(protocolPath)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("invalid protocol specification %s: %w", protocolPath, err)
+	}
+	log.Info("configuration loaded", "config", conf.String())
+	listener, err := net.Listen("tcp4", ":"+strconv.Itoa(int(conf.ServerPort)))
+	if err != nil {
+		return nil, fmt.Errorf("can't listen on port %d: %w", conf.ServerPort, err)
+	}
+	var res = newServer(conf, Deps{Listener: listener, Logger: log, LogLevel: level, Protocol: spec})
+	//Хранилище замеряет время запросов в метрики сервера, поэтому создаётся после него
+	if res.store, err = newSQLStore(conf, res.metrics, res.logger); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	res.configPath = path
+	return res, nil
+}
+
+//Создаёт сервер с настройками conf и зависимостями deps
+func newServer(conf configs, deps Deps) *server {
+	var res = new(server)
+	res.listener = deps.Listener
+	res.store = deps.Store
+	res.clock = deps.Clock
+	if res.clock == nil {
+		res.clock = systemClock{}
+	}
+	var source = deps.Rand
+	if source == nil {
+		source = rand.NewSource(time.Now().UnixNano())
 	}
-	res.db = db
-	res.port = conf.ServerPort
-	res.active = true
+	res.rand = rand.New(&lockedSource{source: source})
+	res.participants = deps.Participants
+	if res.participants == nil {
+		res.participants = readParticipants
+	}
+	res.logger = deps.Logger
+	if res.logger == nil {
+		res.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	res.logLevel = deps.LogLevel
+	if res.logLevel == nil {
+		res.logLevel = new(slog.LevelVar)
+	}
+	res.metrics = newServerMetrics()
+	res.protocol = deps.Protocol
+	res.active.Store(true)
 	res.connectedClient = make(map[*connectedClient]*Lobby, MaxPlayers)
 	res.playingLobbies = make(map[uint]*Lobby)
+	res.userLimiters = make(map[string]*rateLimiter)
+	res.fields = make(map[fieldKey]Field)
 	res.gamesToPlay = conf.GamesToPlay
+	if conf.PairedGames {
+		res.gamesToPlay *= 2
+	}
 	res.Configs = conf
+	res.limits = newGameLimits(conf)
 	return res
 }
 
-//Запускает сервер, который запускает инициализацию всех необходимых таблиц в БД, создаёт расписание матчей
-//и начинает принимать входящие подключения
+//Запускает сервер вместе с консолью администратора, обработкой сигналов, метриками и перезагрузкой настроек
 func (s *server) Start() {
-	fmt.Println("Server started!")
 	go s.commandsHandler()
+	go s.handleSignals()
+	s.startMetrics()
+	go s.handleReload()
+	go s.watchConfigs()
+	s.Serve()
+}
+
+//Инициализирует все необходимые таблицы в БД, создаёт расписание матчей и принимает входящие подключения, пока
+//сервер не остановят
+func (s *server) Serve() {
+	s.logger.Info("server started", "addr", s.listener.Addr().String())
+	if err := s.store.Migrate(); err != nil {
+		s.logger.Error("can't prepare database", "err", err)
+	}
 	s.updateUsers()
 	s.createSchedule()
-	s.createGameResults()
 	s.createLobbies()
-	s.createStats()
-	for s.active {
-		fmt.Println("Waiting for connection")
+	for s.active.Load() {
+		s.logger.Debug("waiting for connection")
 		conn, err := s.listener.Accept()
 		if err != nil {
-			_, _ = os.Stderr.Write([]byte(err.Error()))
+			if !s.active.Load() {
+				break
+			}
+			s.logger.Error("accept failed", "err", err)
 			continue
 		}
 		s.addNewClient(conn)
-		fmt.Printf("User [%s] connected\n", conn.RemoteAddr().String())
 	}
+	s.shutdown()
 }
 
-//Добавляет нового клиента и устанавливает ему пустое лобби
+//Добавляет нового клиента и устанавливает ему пустое лобби. Если превышены ограничения на количество
+//подключений, то клиенту отправляется отказ и соединение закрывается
 func (s *server) addNewClient(conn net.Conn) {
+	if reason := s.admit(conn); reason != "" {
+		s.reject(conn, reason)
+		return
+	}
+	if timeout := s.loginTimeout(); timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
 	var cc = new(connectedClient)
 	*cc = connectedClient{
 		conn:                  conn,
 		name:                  "",
 		dataReceivedListeners: nil,
 		active:                false,
+		limiter:               newRateLimiter(),
+		server:                s,
 	}
 	cc.AddListener(s.dataReceived)
-	cc.StartCommunicator()
 	s.clientsMapMutex.Lock()
 	s.connectedClient[cc] = nil
 	s.clientsMapMutex.Unlock()
+	cc.StartCommunicator()
+	s.logger.Info("client connected", "addr", conn.RemoteAddr().String())
 }
 
 func (s *server) commandsHandler() {
-	for s.active {
-		var str string
-		_, _ = fmt.Scanf("%s\n", &str)
+	var scanner = bufio.NewScanner(os.Stdin)
+	for s.active.Load() && scanner.Scan() {
+		var str, arg = splitCommand(scanner.Text())
 		switch str {
 		case "exit":
-			s.active = false
-			_ = s.listener.Close()
+			s.stop()
 		case "stats":
-			res := s.getStats()
+			res, _ := s.getStats()
 			for i, val := range res {
 				fmt.Printf("%d. %s \t %d\n", i+1, val.Name, val.Points)
 			}
+		case "pairs":
+			pairs, _ := s.getPairResults()
+			for _, val := range pairs {
+				fmt.Printf("%s: %s %d - %d %s (%d games)\n", val.Pair, val.Players[0], val.Points[0], val.Points[1], val.Players[1], val.Games)
+			}
+		case "playerstats":
+			stats, _ := s.getPlayerStats("")
+			for _, val := range stats {
+				fmt.Printf("%s: %d moves, mean %d ms, p95 %d ms, max %d ms, %d timeouts\n", val.Name, val.Moves, val.Mean, val.P95, val.Max, val.Timeouts)
+			}
 		case "delete results":
-			_, _ = s.db.Exec("DELETE FROM game_results")
+			if err := s.store.DeleteResults(); err != nil {
+				fmt.Println(err.Error())
+			}
 		case "update users":
 			s.updateUsers()
+		case "reload":
+			s.reload()
 		case "delete users":
-			_, _ = s.db.Exec("DELETE FROM user")
+			if err := s.store.DeleteUsers(); err != nil {
+				fmt.Println(err.Error())
+			}
 		case "create schedule":
 			s.createSchedule()
 		case "delete lobbies":
-			_, _ = s.db.Exec("DELETE FROM lobbies")
+			if err := s.store.DeleteLobbies(); err != nil {
+				fmt.Println(err.Error())
+			}
 		case "create lobbies":
 			s.createLobbies()
+		case "field":
+			var id, _ = strconv.Atoi(arg)
+			info, err := s.getLobby(id)
+			if err != nil {
+				fmt.Println(err.Error())
+				break
+			}
+			field, err := s.field(info)
+			if err != nil {
+				fmt.Println(err.Error())
+				break
+			}
+			data, _ := json.Marshal(field)
+			fmt.Printf("Lobby %s, seed %d: %s\n", info.Name, info.Seed, string(data))
+		case "adjourn":
+			var id, _ = strconv.Atoi(arg)
+			if err := s.adjournLobby(id); err != nil {
+				fmt.Println(err.Error())
+			}
+		case "resume":
+			var id, _ = strconv.Atoi(arg)
+			if err := s.resumeLobby(id); err != nil {
+				fmt.Println(err.Error())
+			}
 		case "restart":
 			fmt.Print("Вы точно ходите перезапустить сервер? Никто в данный момент не должен играть [Y/n]")
-			_, _ = fmt.Scanf("%s\n", &str)
+			scanner.Scan()
+			str = strings.TrimSpace(scanner.Text())
 			if str == "Y" || str == "y" {
 				//TODO()
 				fmt.Print("server started\n")
 			}
 		default:
-			fmt.Print("Неизвестная команда. Доступные команды: exit, stats, delete results, update users, delete users, create schedule, delete lobbies, restart\n")
+			fmt.Print("Неизвестная команда. Доступные команды: exit, stats, pairs, playerstats, delete results, update users, reload, delete users, create schedule, delete lobbies, create lobbies, field <id>, adjourn <id>, resume <id>, restart\n")
 		}
 	}
 }
 
+//Отделяет от строки команду администратора и её аргумент
+func splitCommand(line string) (string, string) {
+	var str = strings.Join(strings.Fields(line), " ")
+	for _, command := range argCommands {
+		if strings.HasPrefix(str, command+" ") {
+			return command, strings.TrimPrefix(str, command+" ")
+		}
+	}
+	return str, ""
+}
+
 //Главная функция, обрабатывающая входящие команды
 func (s *server) dataReceived(str string, c *connectedClient) {
+	c.log().Debug("received", "data", strings.TrimSpace(str))
 	re := regexp.MustCompile("[A-Z ]+[A-Z]|(?:{.+})")
 	split := re.FindAllString(str, 2)
-	if len(split) > 0 {
-		switch split[0] {
-		case "CONNECTION":
-			if len(split) == 2 {
-				s.tryLogin(c, split[1])
-			}
-		case "SOCKET JOINLOBBY":
-			if c.name == "" {
-				msg := Message{Msg: "LOGIN FIRST"}
-				data, _ := json.Marshal(msg)
-				c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+	c.reqID = nil
+	var reqIDError string
+	if len(split) == 2 {
+		var id RequestID
+		if json.Unmarshal([]byte(split[1]), &id) == nil && id.ReqID != nil {
+			if len(id.ReqID) > MaxRequestIDLength {
+				reqIDError = "REQ_ID is too long"
+			} else if !strings.ContainsRune("\"-0123456789", rune(id.ReqID[0])) {
+				reqIDError = "REQ_ID must be a string or a number"
 			} else {
-				if len(split) == 2 {
-					s.tryJoinLobby(c, split[1])
-				}
-
-			}
-		case "DISCONNECT":
-			s.disconnect(c)
-		case "GET LOBBY":
-			s.getLobbies(c)
-		case "GET RANDOMLOBBY":
-			var lobbyID = LobbyID{}
-			var data, _ = json.Marshal(lobbyID)
-			c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
-		case "POST LOBBY":
-			id, err := s.postLobby(split[1])
-			if err != nil {
-				msg := Message{Msg: "Неправильно ты, дядя Фёдор, лобби постишь, надо по правилам создавать, читай man"}
-				data, _ := json.Marshal(msg)
-				c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
-			} else {
-				var lobbyID = LobbyID{ID: &id}
-				data, _ := json.Marshal(lobbyID)
-				c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+				c.reqID = id.ReqID
 			}
-		case "SOCKET LEAVELOBBY":
-			s.clientsMapMutex.Lock()
-			if lobby, ok := s.connectedClient[c]; ok && !lobby.isPlaying {
-				lobby.removePlayer(c)
-				s.connectedClient[c] = nil
-				msg := Message{Msg: "OK"}
-				data, _ := json.Marshal(msg)
-				c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+		}
+	}
+	defer func() {
+		c.reqID = nil
+	}()
+	//Частота ограничивается до всех проверок, чтобы ошибочные строки тоже учитывались
+	var command string
+	if len(split) > 0 {
+		command = split[0]
+	}
+	if s.throttle(c, command) {
+		return
+	}
+	if reqIDError != "" {
+		c.sendError(ErrBadRequest, reqIDError)
+		return
+	}
+	if len(split) == 0 {
+		s.metrics.protocolErrors.inc("unknown_command")
+		c.sendError(ErrUnknownCommand, "unknown command")
+		return
+	}
+	//Данные команды проверяются по спецификации протокола, а ход - в лобби
+	var payload string
+	if len(split) == 2 {
+		payload = split[1]
+	}
+	if split[0] != "SOCKET STEP" {
+		if err := s.checkPayload(split[0], payload); err != nil {
+			c.reportError(err)
+			return
+		}
+	}
+	switch split[0] {
+	case "CONNECTION":
+		s.tryLogin(c, split[1])
+	case "SOCKET JOINLOBBY":
+		if c.name == "" {
+			c.sendError(ErrLoginRequired, "log in with CONNECTION first")
+		} else {
+			s.tryJoinLobby(c, split[1])
+		}
+	case "DISCONNECT":
+		s.disconnect(c)
+	case "GET LOBBY":
+		s.getLobbies(c)
+	case "GET RANDOMLOBBY":
+		var lobbyID = LobbyID{}
+		c.reply(lobbyID)
+	case "POST LOBBY":
+		id, err := s.postLobby(split[1])
+		if err != nil {
+			s.metrics.protocolErrors.inc("post_lobby")
+			c.reportError(err)
+		} else {
+			var lobbyID = LobbyID{ID: &id}
+			c.reply(lobbyID)
+		}
+	case "SOCKET LEAVELOBBY":
+		s.clientsMapMutex.Lock()
+		if lobby, ok := s.connectedClient[c]; ok && lobby != nil && !lobby.isPlaying {
+			lobby.removePlayer(c)
+			s.connectedClient[c] = nil
+			msg := Message{Msg: "OK"}
+			c.reply(msg)
+		} else {
+			c.sendError(ErrNotInLobby, "not waiting in any lobby")
+		}
+		s.clientsMapMutex.Unlock()
+	case "GET STATS":
+		stats, err := s.getStats()
+		if err != nil {
+			c.reportError(err)
+			break
+		}
+		c.reply(stats)
+	case "GET PAIRS":
+		pairs, err := s.getPairResults()
+		if err != nil {
+			c.reportError(err)
+			break
+		}
+		c.reply(pairs)
+	case "GET PLAYERSTATS":
+		//Можно запросить статистику одного игрока: GET PLAYERSTATS {"LOGIN":"name"}
+		var loginInfo LoginInfo
+		if len(split) == 2 {
+			if err := json.Unmarshal([]byte(split[1]), &loginInfo); err != nil {
+				c.reportError(err)
+				break
 			}
-			s.clientsMapMutex.Unlock()
-		case "GET STATS":
-			stats := s.getStats()
-			data, _ := json.Marshal(stats)
-			c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
 		}
+		stats, err := s.getPlayerStats(loginInfo.Login)
+		if err != nil {
+			c.reportError(err)
+			break
+		}
+		c.reply(stats)
+	case "GET FIELD":
+		s.sendField(c, split[1])
+	case "SOCKET STEP":
+		s.checkPlaying(c)
+	default:
+		//Ход можно прислать и без SOCKET STEP
+		if strings.HasPrefix(split[0], "{") {
+			s.checkPlaying(c)
+			return
+		}
+		s.metrics.protocolErrors.inc("unknown_command")
+		c.sendError(ErrUnknownCommand, "unknown command "+split[0])
 	}
 }
 
@@ -224,52 +507,70 @@ func (s *server) login(str string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	rows, err2 := s.db.Query("SELECT * FROM user WHERE login = ?", loginInfo.Login)
-	if rows != nil {
-		defer rows.Close()
-	}
+	found, err2 := s.store.HasUser(loginInfo.Login)
 	if err2 != nil {
 		return "", err2
 	}
-	if rows.Next() {
+	if found {
 		return loginInfo.Login, nil
 	} else {
-		return "", errors.New("login failed")
+		return "", newError(ErrLoginFailed, "unknown login "+loginInfo.Login)
 	}
 
 }
 
+//Файл со списком участников, по логину на строку
+const ParticipantsPath = "resources/participants_list"
+
 //Обновляет список пользователей, который берется из файла /resources/participants_list
 func (s *server) updateUsers() {
-	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS user ( `ID` INT UNSIGNED NOT NULL AUTO_INCREMENT , `login` VARCHAR(20) NOT NULL , PRIMARY KEY (`ID`), UNIQUE `login` (`login`)) ENGINE = InnoDB;")
+	s.configsMutex.Lock()
+	defer s.configsMutex.Unlock()
+	listUsers, err := s.participants()
 	if err != nil {
-		logError(236, err.Error())
+		s.logger.Error("can't open participants list", "err", err)
 	}
-	var users, err2 = os.Open("resources/participants_list")
-	if err2 != nil {
-		logError(240, err2.Error())
+	s.competitors = make([]string, 0, len(listUsers))
+	s.competitors = append(s.competitors, listUsers...)
+	for _, user := range listUsers {
+		s.addUser(user)
+	}
+}
+
+//Читает логины участников из файла ParticipantsPath. Пустые строки пропускаются
+func readParticipants() ([]string, error) {
+	var users, err = os.Open(ParticipantsPath)
+	if err != nil {
+		return nil, err
 	}
+	defer users.Close()
 	var reader = bufio.NewReader(users)
 	var listUsers = make([]string, 0, MaxPlayers)
 	for {
-		user, _, err3 := reader.ReadLine()
-		if err3 != nil {
+		user, _, err2 := reader.ReadLine()
+		if err2 != nil {
 			break
 		}
-		listUsers = append(listUsers, string(user))
-	}
-	s.competitors = make([]string, 0, len(listUsers))
-	s.competitors = append(s.competitors, listUsers...)
-	for _, user := range listUsers {
-		_, err = s.db.Exec("INSERT INTO user VALUES (null ,?) ON DUPLICATE KEY UPDATE `login` = ?", user, user)
-		if err != nil {
-			logError(256, err.Error())
+		if len(bytes.TrimSpace(user)) > 0 {
+			listUsers = append(listUsers, string(bytes.TrimSpace(user)))
 		}
 	}
+	return listUsers, nil
+}
+
+//Добавляет пользователя в таблицу user, если его там ещё нет
+func (s *server) addUser(user string) {
+	if err := s.store.AddUser(user); err != nil {
+		s.logger.Error("can't add user", "user", user, "err", err)
+	}
 }
 
 //Создаёт расписание матчей по круговой системе. Обязательно чётное количество участников
 func (s *server) createSchedule() {
+	s.configsMutex.Lock()
+	defer s.configsMutex.Unlock()
+	s.scheduleMutex.Lock()
+	defer s.scheduleMutex.Unlock()
 	s.schedule = make(map[string][]string, len(s.competitors))
 	for _, val := range s.competitors {
 		s.schedule[val] = make([]string, 0, uint(len(s.competitors)-1)*s.gamesToPlay)
@@ -288,39 +589,115 @@ func (s *server) createSchedule() {
 	}
 }
 
-//Создаёт (если не существует) таблицу в БД с результатами матчей и добавляет внешние ключи
-func (s *server) createGameResults() {
-	_, err := s.db.Exec("SELECT * FROM game_results")
-	if err != nil {
-		_, _ = s.db.Exec("CREATE TABLE game_results ( `first` VARCHAR(20) NOT NULL , `second` VARCHAR(20) NOT NULL , `result` SET('first','second','draw') NOT NULL, CONSTRAINT `first` FOREIGN KEY (first) REFERENCES user(login) ON DELETE RESTRICT ON UPDATE RESTRICT, CONSTRAINT `second` FOREIGN KEY (second) REFERENCES user(login) ON DELETE RESTRICT ON UPDATE RESTRICT) ENGINE = InnoDB;")
+//Заполняет таблицу lobbies лобби для всех матчей расписания. Поля генерируются без блокировки настроек, по их
+//копии
+func (s *server) createLobbies() {
+	s.configsMutex.Lock()
+	var conf = s.Configs
+	var competitors = append([]string{}, s.competitors...)
+	s.configsMutex.Unlock()
+	for i := 0; i < len(competitors)-1; i++ {
+		for j := i + 1; j < len(competitors); j++ {
+			s.createPairLobbies(conf, competitors[i], competitors[j])
+		}
 	}
 }
 
-//Создаёт таблицу lobbies, если не существует, и заполняет её данными из расписания матчей
-func (s *server) createLobbies() {
-	_, _ = s.db.Exec("CREATE TABLE IF NOT EXISTS lobbies ( `ID` INT UNSIGNED NOT NULL AUTO_INCREMENT , `width` INT UNSIGNED NOT NULL , `height` INT UNSIGNED NOT NULL , `gameBarrierCount` INT UNSIGNED NOT NULL , `playerBarrierCount` INT UNSIGNED NOT NULL , `name` VARCHAR(100) NOT NULL , `playersCount` INT UNSIGNED NOT NULL , PRIMARY KEY (`ID`), UNIQUE `name` (`name`)) ENGINE = InnoDB;")
-	for i := 0; i < len(s.competitors)-1; i++ {
-		for j := i + 1; j < len(s.competitors); j++ {
-			for k := uint(0); k < s.gamesToPlay; k++ {
-				var width = rand.Uint32()%5 + 5
-				var height = rand.Uint32()%5 + 5
-				var gameBarriersCount = rand.Uint32()%3 + uint32(math.Log(float64(width+height)/2.0)/math.Log(3))
-				var playersBarrierCount = rand.Uint32()%3 + 1
-				_, _ = s.db.Exec("INSERT INTO lobbies VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE `name` = ?", nil, width, height, gameBarriersCount, playersBarrierCount, fmt.Sprintf("%s_vs_%s_%d", s.competitors[i], s.competitors[j], k+1), 2, fmt.Sprintf("%s_vs_%s_%d", s.competitors[i], s.competitors[j], k+1))
+//Генератор случайных чисел для параметров лобби участников first и second. Зерно генератора получается из зерна
+//seed из настроек и логинов пары, поэтому у пары всегда одни и те же лобби, а у разных пар они разные, в каком бы
+//порядке лобби ни создавались. Если зерно в настройках не задано, то лобби каждый раз получаются разными
+func (s *server) pairRand(seed int64, first, second string) *rand.Rand {
+	if seed == 0 {
+		return rand.New(rand.NewSource(s.rand.Int63()))
+	}
+	var hash = fnv.New64a()
+	hash.Write([]byte(first + "_vs_" + second))
+	return rand.New(rand.NewSource(seed + int64(hash.Sum64())))
+}
+
+//Добавляет в таблицу lobbies gamesToPlay лобби для игр участников first и second по настройкам conf. Генерация
+//полей может занять секунды, поэтому настройки передаются копией, а не читаются под configsMutex
+func (s *server) createPairLobbies(conf configs, first, second string) {
+	var rnd = s.pairRand(conf.Seed, first, second)
+	var info LobbyInfo
+	for k := uint(0); k < s.gamesToPlay; k++ {
+		//Вторая игра пары играется на том же поле, что и первая
+		if !conf.PairedGames || k%2 == 0 {
+			var width = rnd.Uint32()%5 + 5
+			var height = rnd.Uint32()%5 + 5
+			var gameBarriersCount = rnd.Uint32()%3 + uint32(math.Log(float64(width+height)/2.0)/math.Log(3))
+			var playersBarrierCount = rnd.Uint32()%3 + 1
+			info = LobbyInfo{
+				Width:              uint16(width),
+				Height:             uint16(height),
+				GameBarrierCount:   uint16(gameBarriersCount),
+				PlayerBarrierCount: uint8(playersBarrierCount),
+				PlayersCount:       2,
+				TimeControl:        conf.TimeControl,
+				TimeBank:           conf.TimeBank,
+				Increment:          conf.Increment,
+				Delay:              conf.Delay,
+				Seed:               rnd.Int63(),
+				Generator:          conf.Generator,
+				Tolerance:          conf.FairnessTolerance,
+				Jumps:              conf.Jumps,
+				Variant:            conf.Variant,
+				Visibility:         conf.Visibility,
 			}
 		}
+		info.Name = fmt.Sprintf("%s_vs_%s_%d", first, second, k+1)
+		if conf.PairedGames {
+			info.Pair = fmt.Sprintf("%s_vs_%s_pair%d", first, second, k/2+1)
+			if k%2 == 0 {
+				info.FirstPlayer = first
+			} else {
+				info.FirstPlayer = second
+			}
+		}
+		//После перезапуска лобби расписания уже есть в таблице, их параметры обновляются
+		var lobby, err = s.completeLobby(info)
+		if err == nil {
+			err = s.store.UpsertLobby(lobby)
+		}
+		if err != nil {
+			s.logger.Error("can't save scheduled lobby", "lobby", info.Name, "err", err)
+		}
 	}
 }
 
-func (s *server) createStats() {
-	_, _ = s.db.Exec("create view if not exists stats as " +
-		"select login, sum(Points) as pts from " +
-		"(select user.login, Count(*)*3 as Points from user inner join game_results on user.login=game_results.first where result='first' group by ID " +
-		"union all " +
-		"select user.login, Count(*)*3 from user inner join game_results on user.login=game_results.second where result='second' group by ID " +
-		"union all " +
-		"select user.login, Count(*) from user inner join game_results on (user.login=game_results.first or user.login=game_results.second) where result='draw' group by ID" +
-		") as temporary group by login;")
+//Добавляет лобби в таблицу lobbies
+func (s *server) insertLobby(info LobbyInfo) (int64, error) {
+	info, err := s.completeLobby(info)
+	if err != nil {
+		return 0, err
+	}
+	return s.store.InsertLobby(info)
+}
+
+//Дополняет параметры лобби перед сохранением. Если контроль времени не указан, то используется контроль Фишера,
+//если не указано зерно поля, то оно выбирается случайно, если не указан вариант правил, то используются обычные
+//правила. Вместе с лобби сохраняются длины кратчайших путей игроков, поэтому возвращается ошибка, если поле
+//лобби не получается сгенерировать
+func (s *server) completeLobby(info LobbyInfo) (LobbyInfo, error) {
+	if info.TimeControl == "" {
+		info.TimeControl = FischerControl
+	}
+	if info.Seed == 0 {
+		info.Seed = s.rand.Int63()
+	}
+	if info.Generator == "" {
+		info.Generator = RandomGenerator
+	}
+	if info.Variant == "" {
+		info.Variant = board.ClassicVariant
+	}
+	field, err := s.field(info)
+	if err != nil {
+		return info, err
+	}
+	var distance = fieldDistances(field, info.rules())
+	info.Distance, info.OpponentDistance = uint16(distance[0]), uint16(distance[1])
+	return info, nil
 }
 
 //Пытается найти подходящее лобби для игрока с именем name. str - {"id":string}
@@ -332,72 +709,57 @@ func (s *server) joinLobby(str, name string) (JoinLobbyResponse, error) {
 	}
 	if lobbyID.ID != nil {
 		var i, _ = strconv.Atoi(*lobbyID.ID)
-		rows, err := s.db.Query("SELECT * FROM lobbies WHERE ID = ?", i)
+		lobbyInfo, err := s.getLobby(i)
 		if err != nil {
 			return JoinLobbyResponse{}, err
 		}
-		defer rows.Close()
-		if rows.Next() {
-			var joinLobbyResponse JoinLobbyResponse
-			var id uint
-			var width, height, gameBarrierCount, playerBarrierCount, playersCount uint8
-			var n string
-			_ = rows.Scan(&id, &width, &height, &gameBarrierCount, &playerBarrierCount, &n, &playersCount)
-			var ID = strconv.Itoa(int(id))
-			var lobbyInfo = LobbyInfo{
-				ID:                 &ID,
-				Width:              width,
-				Height:             height,
-				GameBarrierCount:   gameBarrierCount,
-				PlayerBarrierCount: playerBarrierCount,
-				Name:               n,
-				PlayersCount:       playersCount,
-			}
-			joinLobbyResponse = JoinLobbyResponse{
-				Data:    lobbyInfo,
-				Success: true,
-			}
-			return joinLobbyResponse, nil
-		} else {
-			return JoinLobbyResponse{}, errors.New("lobby with current id don't found")
+		//Отложенную игру можно только продолжить командой resume
+		_, _, adjourned, err := s.store.LastAdjourned(i)
+		if err != nil {
+			return JoinLobbyResponse{}, err
 		}
+		if adjourned {
+			return JoinLobbyResponse{}, newError(ErrLobbyBusy, "lobby "+*lobbyID.ID+" has an adjourned game")
+		}
+		return JoinLobbyResponse{
+			Data:    lobbyInfo,
+			Success: true,
+		}, nil
 	} else {
 		s.scheduleMutex.Lock()
+		if len(s.schedule[name]) == 0 {
+			s.scheduleMutex.Unlock()
+			return JoinLobbyResponse{}, newError(ErrNoScheduledGame, "player has no scheduled games left")
+		}
 		var opponent = s.schedule[name][0]
 		s.schedule[name] = s.schedule[name][1:]
 		s.scheduleMutex.Unlock()
-		rows, err := s.db.Query("SELECT * FROM lobbies WHERE `name` LIKE concat('%', ?, '%') AND `name` LIKE concat('%', ?, '%')", name, opponent)
+		lobbyInfo, found, err := s.store.PairLobby(name, opponent)
 		if err != nil {
 			return JoinLobbyResponse{}, err
 		}
-		defer rows.Close()
-		if rows.Next() {
+		if found {
 			var joinLobbyResponse JoinLobbyResponse
-			var id uint
-			var width, height, gameBarrierCount, playerBarrierCount, playersCount uint8
-			var name string
-			_ = rows.Scan(&id, &width, &height, &gameBarrierCount, &playerBarrierCount, &name, &playersCount)
-			var ID = strconv.Itoa(int(id))
-			var lobbyInfo = LobbyInfo{
-				ID:                 &ID,
-				Width:              width,
-				Height:             height,
-				GameBarrierCount:   gameBarrierCount,
-				PlayerBarrierCount: playerBarrierCount,
-				Name:               name,
-				PlayersCount:       playersCount,
-			}
 			joinLobbyResponse = JoinLobbyResponse{
 				Data:    lobbyInfo,
 				Success: true,
 			}
 			return joinLobbyResponse, nil
 		} else {
-			return JoinLobbyResponse{}, errors.New("probably player played all his games, can't find lobby with his name")
+			return JoinLobbyResponse{}, newError(ErrNoScheduledGame, "probably player played all his games, can't find lobby with his name")
 		}
 	}
 }
 
+//Возвращает лобби с идентификатором id
+func (s *server) getLobby(id int) (LobbyInfo, error) {
+	info, found, err := s.store.Lobby(id)
+	if err != nil || found {
+		return info, err
+	}
+	return LobbyInfo{}, newError(ErrLobbyNotFound, "lobby "+strconv.Itoa(id)+" not found")
+}
+
 //Создаёт лобби
 func (s *server) postLobby(str string) (string, error) {
 	var lobbyInfo LobbyInfo
@@ -405,124 +767,270 @@ func (s *server) postLobby(str string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	res, err2 := s.db.Exec("INSERT INTO lobbies VALUES (?, ?, ?, ?, ?, ?, ?)", nil, lobbyInfo.Width, lobbyInfo.Height, lobbyInfo.GameBarrierCount, lobbyInfo.PlayerBarrierCount, lobbyInfo.Name, lobbyInfo.PlayersCount)
+	if lobbyInfo.TimeControl != "" && lobbyInfo.TimeControl != FischerControl && lobbyInfo.TimeControl != BronsteinControl {
+		return "", newError(ErrInvalidLobby, "unknown time control "+lobbyInfo.TimeControl)
+	}
+	if lobbyInfo.Generator != "" && lobbyInfo.Generator != RandomGenerator && lobbyInfo.Generator != BalancedGenerator &&
+		lobbyInfo.Generator != SymmetricGenerator {
+		return "", newError(ErrInvalidLobby, "unknown field generator "+lobbyInfo.Generator)
+	}
+	if err = checkFieldParams(lobbyInfo); err != nil {
+		return "", newError(ErrInvalidLobby, err.Error())
+	}
+	id, err2 := s.insertLobby(lobbyInfo)
 	if err2 != nil {
 		return "", err2
 	}
-	id, err3 := res.LastInsertId()
-	if err3 != nil {
-		return "", err3
-	}
 	return strconv.Itoa(int(id)), nil
 }
 
-//Отправляет результаты в БД и удалет лобби
-func (s *server) deleteLobby(res result, lobby *Lobby, client, client2 *connectedClient) {
-	_, err := s.db.Exec("INSERT INTO game_results VALUES (? ,?, ?)", res.first, res.second, res.result)
-	if err != nil {
-		logError(444, err.Error())
+//Отправляет результаты в БД и удалет лобби. Результаты игры двух игроков и командной игры записываются в
+//game_results, игры большего количества игроков - в multi_results, по строке на каждого игрока
+func (s *server) deleteLobby(res result, lobby *Lobby, players []*connectedClient) {
+	var game = lobby.game
+	if res.adjourned != nil {
+		s.saveAdjourned(game, res.adjourned)
+	} else if res.result != "" {
+		err := s.store.SaveResult(GameResult{First: res.first, Second: res.second, Result: res.result, Reason: res.reason,
+			Pair: lobby.Info.Pair, FirstPartner: res.partners[0], SecondPartner: res.partners[1]})
+		if err != nil {
+			lobby.log().Error("can't save game result", "err", err)
+		}
+	} else {
+		for i, val := range res.players {
+			err := s.store.SavePlace(game, val, res.places[i], placePoints(res.places[i], len(res.players), res.reason), res.reason)
+			if err != nil {
+				lobby.log().Error("can't save game result", "client", val, "err", err)
+			}
+		}
+	}
+	for _, val := range res.timings {
+		err := s.store.SaveMoveTime(game, res.players[val.player], val.turn, val.millis(), val.timeout)
+		if err != nil {
+			lobby.log().Error("can't save move time", "turn", val.turn, "err", err)
+		}
 	}
 	s.clientsMapMutex.Lock()
-	s.connectedClient[client] = nil
-	s.connectedClient[client2] = nil
+	for _, val := range players {
+		s.connectedClient[val] = nil
+	}
 	s.clientsMapMutex.Unlock()
 	var id, _ = strconv.Atoi(*lobby.Info.ID)
 	s.lobbiesMutex.Lock()
 	delete(s.playingLobbies, uint(id))
 	s.lobbiesMutex.Unlock()
-	_, _ = s.db.Exec("DELETE FROM lobbies WHERE ID = ?", id)
-	client.readMutex.Unlock()
-	client2.readMutex.Unlock()
+	//Лобби отложенной игры остаётся, чтобы её можно было продолжить
+	if res.adjourned == nil {
+		_ = s.store.DeleteLobby(id)
+	}
+	for _, val := range players {
+		val.readMutex.Unlock()
+	}
+}
+
+//Очки за место place в игре count игроков, в той же шкале, что и в игре двух игроков: за первое место 3 очка,
+//за последнее - ни одного, за ничью - 1 очко
+func placePoints(place uint8, count int, reason string) uint8 {
+	if isDraw(reason) {
+		return 1
+	}
+	return uint8(3 * (count - int(place)) / (count - 1))
 }
 
 //Возвращает таблицу с текущими результатами
-func (s *server) getStats() []Stats {
-	rows, _ := s.db.Query("SELECT * FROM stats ORDER BY pts DESC")
-	var stats = make([]Stats, 0, MaxPlayers)
-	for rows.Next() {
-		var login string
-		var pts uint16
-		_ = rows.Scan(&login, &pts)
-		stats = append(stats, Stats{
-			Name:   login,
-			Points: pts,
-		})
+func (s *server) getStats() ([]Stats, error) {
+	stats, err := s.store.Stats()
+	if err != nil {
+		s.logger.Error("can't read stats", "err", err)
+	}
+	return stats, err
+}
+
+//Возвращает статистику времени ходов каждого игрока по всем сыгранным играм, или только игрока login, если он
+//не пустой
+func (s *server) getPlayerStats(login string) ([]PlayerStats, error) {
+	var res = make([]PlayerStats, 0)
+	moves, err := s.store.MoveTimes(login)
+	if err != nil {
+		s.logger.Error("can't read move times", "err", err)
+		return res, err
 	}
-	return stats[:]
+	var name string
+	var times []uint32
+	var timeouts uint32
+	for _, val := range moves {
+		if val.Login != name && len(times) > 0 {
+			res = append(res, thinkStats(name, times, timeouts))
+			times, timeouts = nil, 0
+		}
+		name = val.Login
+		times = append(times, val.Ms)
+		if val.Timeout {
+			timeouts += 1
+		}
+	}
+	if len(times) > 0 {
+		res = append(res, thinkStats(name, times, timeouts))
+	}
+	return res, nil
+}
+
+//Возвращает результаты парных игр, сгруппированные по парам
+func (s *server) getPairResults() ([]PairResult, error) {
+	var pairs = make([]PairResult, 0)
+	games, err := s.store.PairGames()
+	if err != nil {
+		s.logger.Error("can't read pair results", "err", err)
+		return pairs, err
+	}
+	for _, val := range games {
+		var pair, first, second, res = val.Pair, val.First, val.Second, val.Result
+		if len(pairs) == 0 || pairs[len(pairs)-1].Pair != pair {
+			pairs = append(pairs, PairResult{Pair: pair, Players: [2]string{first, second}})
+		}
+		var current = &pairs[len(pairs)-1]
+		var firstIndex = 0
+		if current.Players[0] != first {
+			firstIndex = 1
+		}
+		switch res {
+		case "first":
+			current.Points[firstIndex] += 3
+		case "second":
+			current.Points[1-firstIndex] += 3
+		case "draw":
+			current.Points[0] += 1
+			current.Points[1] += 1
+		}
+		current.Games += 1
+	}
+	return pairs, nil
+}
+
+//Ходы игроков принимает лобби, здесь только проверяется, что клиент сейчас играет
+func (s *server) checkPlaying(c *connectedClient) {
+	if !s.isPlaying(c) {
+		c.sendError(ErrNotPlaying, "move sent outside of a game")
+	}
+}
+
+//Играет ли клиент сейчас
+func (s *server) isPlaying(c *connectedClient) bool {
+	s.clientsMapMutex.Lock()
+	defer s.clientsMapMutex.Unlock()
+	var lobby = s.connectedClient[c]
+	return lobby != nil && lobby.isPlaying
 }
 
 func (s *server) tryLogin(c *connectedClient, str string) {
 	var err error
 	c.name, err = s.login(str)
 	if err != nil {
-		msg := Message{Msg: "LOGIN FAILED"}
-		data, _ := json.Marshal(msg)
-		c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+		c.reportError(err)
 	} else {
+		_ = c.conn.SetReadDeadline(time.Time{})
 		msg := Message{Msg: "LOGIN OK"}
-		data, _ := json.Marshal(msg)
-		c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+		c.reply(msg)
 	}
 }
 
 func (s *server) tryJoinLobby(c *connectedClient, str string) {
 	res, err := s.joinLobby(str, c.name)
+	//Во время остановки сервера новые игры не начинаются
+	if err == nil && !s.active.Load() {
+		err = newError(ErrShuttingDown, "server is shutting down")
+	}
+	//Поле генерируется до того, как игрок зайдёт в лобби, чтобы игра не начиналась с поля, которого нет
+	var field Field
+	if err == nil {
+		field, err = s.field(res.Data)
+	}
 	if err != nil {
-		logError(492, err.Error())
-		jLR := JoinLobbyResponse{
-			Data:    LobbyInfo{},
-			Success: false,
-		}
-		data, _ := json.Marshal(jLR)
-		c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+		c.log().Warn("can't join lobby", "err", err)
+		s.metrics.protocolErrors.inc("join_lobby")
+		c.reportError(err)
 		s.clientsMapMutex.Lock()
 		s.connectedClient[c] = nil
 		s.clientsMapMutex.Unlock()
 	} else {
-		data, _ := json.Marshal(res)
 		i, _ := strconv.Atoi(*res.Data.ID)
 		s.lobbiesMutex.Lock()
-		if lobby, ok := s.playingLobbies[uint(i)]; !ok || lobby.expectingPlayer == nil {
+		lobby, ok := s.playingLobbies[uint(i)]
+		if !ok || lobby == nil {
 			//JoinLobby, но никто ещё не подключался
-			s.clientsMapMutex.Lock()
-			if lobby == nil {
-				lobby = new(Lobby)
-				*lobby = Lobby{
-					Info:            res.Data,
-					expectingPlayer: c,
-					isPlaying:       false,
-					channel:         make(chan string, 1),
-					results:         make(chan result, 1),
-				}
+			lobby = s.newLobby(res.Data)
+			lobby.field = field
+			s.playingLobbies[uint(i)] = lobby
+		}
+		if lobby.isPlaying || lobby.Info.teamGame() && lobby.Info.teamOf(c.name) < 0 {
+			//Лобби создано, но там уже кто-то играет, либо игрок не состоит ни в одной из команд
+			c.log().Info("lobby is already playing or player is not in its teams", "lobby", *res.Data.ID)
+			if lobby.isPlaying {
+				c.sendError(ErrLobbyBusy, "a game is already running in lobby "+*res.Data.ID)
 			} else {
-				lobby.expectingPlayer = c
+				c.sendError(ErrNotInTeam, "player is not in the teams of lobby "+*res.Data.ID)
 			}
-			s.playingLobbies[uint(i)] = lobby
-			s.connectedClient[c] = lobby
-			s.clientsMapMutex.Unlock()
-			c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
-		} else if lobby.isPlaying {
-			//Лобби создано, но там уже кто-то играет
-			fmt.Printf("Player %s trying join lobby that already playing game\n", c.name)
-			c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
 			s.clientsMapMutex.Lock()
 			s.connectedClient[c] = nil
 			s.clientsMapMutex.Unlock()
-		} else if lobby.expectingPlayer != nil {
-			//Лобби создано и там кто-то ждёт
-			c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+		} else {
+			s.clientsMapMutex.Lock()
 			s.connectedClient[c] = lobby
-			time.Sleep(1 * time.Second)
-			go func() {
-				lobby.playGame(c, lobby.expectingPlayer)
-				gameResult := <-lobby.results
-				s.deleteLobby(gameResult, lobby, c, lobby.expectingPlayer)
-			}()
+			s.clientsMapMutex.Unlock()
+			c.reply(res)
+			//Если лобби заполнилось, то начинается игра
+			if lobby.addPlayer(c) {
+				lobby.isPlaying = true
+				var players = lobby.expectingPlayers
+				lobby.expectingPlayers = nil
+				sleep(s.clock, GameStartDelay)
+				s.startGame(lobby, players, func() {
+					lobby.playGame(players)
+				})
+			}
 		}
 		s.lobbiesMutex.Unlock()
 	}
 }
 
+//Запускает игру play в лобби в отдельной горутине и после её окончания сохраняет результаты и удаляет лобби
+func (s *server) startGame(lobby *Lobby, players []*connectedClient, play func()) {
+	s.games.Add(1)
+	go func() {
+		defer s.games.Done()
+		play()
+		gameResult := <-lobby.results
+		s.deleteLobby(gameResult, lobby, players)
+	}()
+}
+
+//Отправляет клиенту начальное поле лобби. str - {"_id":string} для существующего лобби, либо параметры
+//лобби с зерном: {"width":uint16,"height":uint16,"gameBarrierCount":uint16,"seed":int64}
+func (s *server) sendField(c *connectedClient, str string) {
+	var info LobbyInfo
+	err := json.Unmarshal([]byte(str), &info)
+	if err == nil && info.ID != nil {
+		var id, _ = strconv.Atoi(*info.ID)
+		info, err = s.getLobby(id)
+	}
+	var field Field
+	if err == nil {
+		if err = checkFieldParams(info); err == nil {
+			field, err = s.field(info)
+		}
+		if err != nil {
+			err = newError(ErrInvalidField, err.Error())
+		}
+	}
+	if err != nil {
+		c.log().Warn("wrong field parameters", "err", err)
+		s.metrics.protocolErrors.inc("field")
+		c.reportError(err)
+		return
+	}
+	c.reply(field)
+}
+
 func (s *server) disconnect(c *connectedClient) {
 	s.clientsMapMutex.Lock()
 	if lobby, ok := s.connectedClient[c]; ok && lobby != nil {
@@ -530,69 +1038,27 @@ func (s *server) disconnect(c *connectedClient) {
 	}
 	s.clientsMapMutex.Unlock()
 	msg := Message{Msg: "BYE"}
-	data, _ := json.Marshal(msg)
-	c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+	c.reply(msg)
 	c.Stop()
-	var id = func() string {
-		if c.name == "" {
-			return c.conn.RemoteAddr().String()
-		} else {
-			return c.name
-		}
-	}()
-	fmt.Printf("User %s disconnected\n", id)
+	c.log().Info("client disconnected")
+	s.clientsMapMutex.Lock()
 	delete(s.connectedClient, c)
+	s.clientsMapMutex.Unlock()
 }
 
 func (s *server) getLobbies(c *connectedClient) {
 	//s.updateLobbies()
-	var infos = make([]LobbyInfo, 0, MaxPlayers*(MaxPlayers-1)/2*s.gamesToPlay)
 	var getLobbyResponse GetLobbyResponse
-	rows, err := s.db.Query("SELECT * from lobbies")
+	infos, err := s.store.Lobbies()
 	if err != nil {
-		getLobbyResponse = GetLobbyResponse{
-			Data:    nil,
-			Success: false,
-		}
+		s.logger.Error("can't read lobbies", "err", err)
+		c.reportError(err)
+		return
 	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var lobbyInfo LobbyInfo
-			err2 := rows.Scan(&lobbyInfo.ID, &lobbyInfo.Width, &lobbyInfo.Height, &lobbyInfo.GameBarrierCount, &lobbyInfo.PlayerBarrierCount, &lobbyInfo.Name, &lobbyInfo.PlayersCount)
-			if err2 != nil {
-				logError(595, err2.Error())
-			}
-			infos = append(infos, lobbyInfo)
-		}
 		getLobbyResponse = GetLobbyResponse{
-			Data:    infos[:],
+			Data:    infos,
 			Success: true,
 		}
 	}
-	data, _ := json.Marshal(getLobbyResponse)
-	c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
-}
-
-//Читает настройки
-func readConfigs() configs {
-	file, err := os.Open("resources/config.json")
-	if err != nil {
-		panic(err)
-	}
-
-	defer file.Close()
-
-	data := make([]byte, 256)
-	_, err = file.Read(data)
-	data = bytes.Trim(data, "\x00")
-	var res configs
-	err = json.Unmarshal(data, &res)
-	if err != nil {
-		panic(err)
-	}
-	return res
-}
-
-func logError(line int, string2 string) {
-	fmt.Printf("Error at line %d: %s\n", line, string2)
+	c.reply(getLobbyResponse)
 }
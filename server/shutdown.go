@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//Останавливает сервер по SIGINT или SIGTERM
+func (s *server) handleSignals() {
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var sig = <-signals
+	s.logger.Info("got signal, stopping server", "signal", sig.String())
+	s.stop()
+}
+
+//Перестаёт принимать подключения и новые игры. Сама остановка происходит в Start, когда закончится цикл
+//приёма подключений
+func (s *server) stop() {
+	s.stopOnce.Do(func() {
+		s.active.Store(false)
+		_ = s.listener.Close()
+	})
+}
+
+//Ждёт окончания идущих игр не дольше shutdownTimeout секунд, откладывает оставшиеся, прощается с клиентами и
+//закрывает БД
+func (s *server) shutdown() {
+	s.configsMutex.Lock()
+	var timeout = s.Configs.ShutdownTimeout
+	s.configsMutex.Unlock()
+	s.logger.Info("waiting for running games", "timeout", timeout)
+	if !s.waitGames(time.Duration(timeout) * time.Second) {
+		s.lobbiesMutex.Lock()
+		for _, val := range s.playingLobbies {
+			if val != nil && val.isPlaying {
+				val.adjournGame()
+			}
+		}
+		s.lobbiesMutex.Unlock()
+		s.games.Wait()
+	}
+	msg := Message{Msg: "BYE"}
+	data, _ := json.Marshal(msg)
+	s.clientsMapMutex.Lock()
+	for c := range s.connectedClient {
+		c.SendData([]byte(fmt.Sprintf("%s\n", string(data))))
+		c.Stop()
+	}
+	s.clientsMapMutex.Unlock()
+	_ = s.store.Close()
+	s.logger.Info("server stopped")
+}
+
+//Ждёт окончания всех игр не дольше timeout. Возвращает false, если игры не успели закончиться
+func (s *server) waitGames(timeout time.Duration) bool {
+	var done = make(chan struct{})
+	go func() {
+		s.games.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -29,33 +29,3 @@ func funcPeek(stack *FuncStack) func(string2 string, client *connectedClient) {
 	}
 	return stack.f
 }
-
-type PositionStack struct {
-	f    [2]uint8
-	next *PositionStack
-}
-
-func posPush(stack **PositionStack, position [2]uint8) {
-	var newRoot = new(PositionStack)
-	*newRoot = PositionStack{
-		f:    position,
-		next: *stack,
-	}
-	*stack = newRoot
-}
-
-func posPop(stack **PositionStack) ([2]uint8, bool) {
-	if *stack == nil {
-		return [2]uint8{}, false
-	}
-	var temp = *stack
-	*stack = (*stack).next
-	return temp.f, true
-}
-
-func posPeek(stack *PositionStack) [2]uint8 {
-	if stack == nil {
-		panic("attempt to funcPeek from empty pos stack")
-	}
-	return stack.f
-}
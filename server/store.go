@@ -0,0 +1,413 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Хранилище сервера: участники, лобби, результаты игр, время ходов и отложенные игры. Сервер работает с MariaDB
+//через sqlStore, а в тестах - с memoryStore
+type Store interface {
+	//Создаёт таблицы, которых ещё нет, и добавляет в них новые столбцы
+	Migrate() error
+	//Добавляет участника, если его ещё нет
+	AddUser(login string) error
+	//Проверяет, есть ли участник с логином login
+	HasUser(login string) (bool, error)
+	//Удаляет всех участников
+	DeleteUsers() error
+	//Сохраняет лобби и возвращает его ID. Если лобби с таким названием уже есть, то возвращает ошибку
+	InsertLobby(info LobbyInfo) (int64, error)
+	//Сохраняет лобби расписания. Если лобби с таким названием уже есть, то обновляет его параметры, кроме лобби
+	//с отложенными играми: они продолжаются с теми параметрами, с которыми начались
+	UpsertLobby(info LobbyInfo) error
+	//Возвращает лобби с ID id. false, если такого лобби нет
+	Lobby(id int) (LobbyInfo, bool, error)
+	//Возвращает первое лобби, в названии которого есть оба логина name и opponent. Лобби с отложенными играми
+	//пропускаются, их игры только продолжают. false, если такого лобби нет
+	PairLobby(name, opponent string) (LobbyInfo, bool, error)
+	//Возвращает все лобби
+	Lobbies() ([]LobbyInfo, error)
+	//Удаляет лобби с ID id
+	DeleteLobby(id int) error
+	//Удаляет все лобби
+	DeleteLobbies() error
+	//Сохраняет результат игры двух игроков или командной игры
+	SaveResult(res GameResult) error
+	//Сохраняет место и очки игрока login в игре game большего количества игроков
+	SavePlace(game, login string, place, points uint8, reason string) error
+	//Сохраняет время хода turn игрока login в игре game
+	SaveMoveTime(game, login string, turn int, ms uint32, timeout bool) error
+	//Удаляет результаты всех игр и время ходов
+	DeleteResults() error
+	//Возвращает очки участников по убыванию
+	Stats() ([]Stats, error)
+	//Возвращает время всех ходов, упорядоченное по логину, или только ходов игрока login, если он не пустой
+	MoveTimes(login string) ([]MoveTime, error)
+	//Возвращает результаты парных игр, упорядоченные по паре
+	PairGames() ([]GameResult, error)
+	//Сохраняет состояние state отложенной игры game лобби lobby
+	SaveAdjourned(game, lobby, state string) error
+	//Возвращает последнюю отложенную игру лобби lobby и её состояние. false, если отложенных игр нет
+	LastAdjourned(lobby int) (string, string, bool, error)
+	//Удаляет отложенную игру game
+	DeleteAdjourned(game string) error
+	//Закрывает хранилище
+	Close() error
+}
+
+//Результат игры двух игроков или командной игры, как он хранится в game_results
+type GameResult struct {
+	First         string
+	Second        string
+	Result        string //first, second или draw
+	Reason        string
+	Pair          string //Пара игр, если игры играются парами
+	FirstPartner  string //Напарник first в командной игре
+	SecondPartner string //Напарник second в командной игре
+}
+
+//Время одного хода игрока, как оно хранится в move_times
+type MoveTime struct {
+	Login   string
+	Ms      uint32
+	Timeout bool
+}
+
+//Хранилище в MariaDB
+type sqlStore struct {
+	db      *sql.DB
+	metrics *serverMetrics //Куда записывается время запросов
+	logger  *slog.Logger
+}
+
+//Открывает БД по настройкам conf. Подключение происходит при первом запросе. Время запросов записывается
+//в metrics, ошибки чтения - в журнал logger
+func newSQLStore(conf configs, metrics *serverMetrics, logger *slog.Logger) (*sqlStore, error) {
+	db, err := sql.Open("mysql", conf.dataSource())
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db, metrics: metrics, logger: logger}, nil
+}
+
+//Выполняет запрос к БД, не возвращающий строк, и замеряет его время
+func (s *sqlStore) exec(query string, args ...any) (sql.Result, error) {
+	var start = time.Now()
+	res, err := s.db.Exec(query, args...)
+	s.metrics.dbLatency.observe(statementKind(query), time.Since(start))
+	return res, err
+}
+
+//Выполняет запрос к БД, возвращающий строки, и замеряет его время
+func (s *sqlStore) query(query string, args ...any) (*sql.Rows, error) {
+	var start = time.Now()
+	rows, err := s.db.Query(query, args...)
+	s.metrics.dbLatency.observe(statementKind(query), time.Since(start))
+	return rows, err
+}
+
+//Создаёт (если не существуют) таблицы user и lobbies и таблицы с результатами матчей и добавляет внешние ключи.
+//Командные игры хранятся в game_results вместе с напарниками firstPartner и secondPartner, остальные игры на
+//троих и четверых - в multi_results, по строке на каждого игрока. Время каждого хода хранится в move_times,
+//состояние отложенных игр - в adjourned_games
+func (s *sqlStore) Migrate() error {
+	var errs []error
+	var check = func(err error, format string) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf(format, err))
+		}
+	}
+	_, err := s.exec("CREATE TABLE IF NOT EXISTS user ( `ID` INT UNSIGNED NOT NULL AUTO_INCREMENT , `login` VARCHAR(20) NOT NULL , PRIMARY KEY (`ID`), UNIQUE `login` (`login`)) ENGINE = InnoDB;")
+	check(err, "can't create table user: %w")
+	_, err = s.exec("SELECT * FROM game_results")
+	if err != nil {
+		_, _ = s.exec("CREATE TABLE game_results ( `first` VARCHAR(20) NOT NULL , `second` VARCHAR(20) NOT NULL , `result` SET('first','second','draw') NOT NULL, CONSTRAINT `first` FOREIGN KEY (first) REFERENCES user(login) ON DELETE RESTRICT ON UPDATE RESTRICT, CONSTRAINT `second` FOREIGN KEY (second) REFERENCES user(login) ON DELETE RESTRICT ON UPDATE RESTRICT) ENGINE = InnoDB;")
+	}
+	_, err = s.exec("ALTER TABLE game_results ADD COLUMN IF NOT EXISTS `reason` VARCHAR(20) NOT NULL DEFAULT '', " +
+		"ADD COLUMN IF NOT EXISTS `pair` VARCHAR(100) NOT NULL DEFAULT '', " +
+		"ADD COLUMN IF NOT EXISTS `firstPartner` VARCHAR(20) NOT NULL DEFAULT '', " +
+		"ADD COLUMN IF NOT EXISTS `secondPartner` VARCHAR(20) NOT NULL DEFAULT ''")
+	check(err, "can't update table game_results: %w")
+	_, err = s.exec("CREATE TABLE IF NOT EXISTS multi_results ( `game` VARCHAR(100) NOT NULL , `login` VARCHAR(20) NOT NULL , `place` TINYINT UNSIGNED NOT NULL , `points` TINYINT UNSIGNED NOT NULL , `reason` VARCHAR(20) NOT NULL DEFAULT '', CONSTRAINT `multi_login` FOREIGN KEY (login) REFERENCES user(login) ON DELETE RESTRICT ON UPDATE RESTRICT) ENGINE = InnoDB;")
+	check(err, "can't create table multi_results: %w")
+	_, err = s.exec("CREATE TABLE IF NOT EXISTS adjourned_games ( `game` VARCHAR(100) NOT NULL , `lobby` INT UNSIGNED NOT NULL , `state` MEDIUMTEXT NOT NULL , `adjourned` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP , PRIMARY KEY (`game`)) ENGINE = InnoDB;")
+	check(err, "can't create table adjourned_games: %w")
+	_, err = s.exec("CREATE TABLE IF NOT EXISTS move_times ( `game` VARCHAR(100) NOT NULL , `login` VARCHAR(20) NOT NULL , `turn` INT UNSIGNED NOT NULL , `ms` INT UNSIGNED NOT NULL , `timeout` BOOL NOT NULL DEFAULT FALSE, CONSTRAINT `move_login` FOREIGN KEY (login) REFERENCES user(login) ON DELETE RESTRICT ON UPDATE RESTRICT) ENGINE = InnoDB;")
+	check(err, "can't create table move_times: %w")
+	_, _ = s.exec("CREATE TABLE IF NOT EXISTS lobbies ( `ID` INT UNSIGNED NOT NULL AUTO_INCREMENT , `width` INT UNSIGNED NOT NULL , `height` INT UNSIGNED NOT NULL , `gameBarrierCount` INT UNSIGNED NOT NULL , `playerBarrierCount` INT UNSIGNED NOT NULL , `name` VARCHAR(100) NOT NULL , `playersCount` INT UNSIGNED NOT NULL , PRIMARY KEY (`ID`), UNIQUE `name` (`name`)) ENGINE = InnoDB;")
+	_, err = s.exec("ALTER TABLE lobbies " +
+		"ADD COLUMN IF NOT EXISTS `timeControl` VARCHAR(10) NOT NULL DEFAULT 'fischer', " +
+		"ADD COLUMN IF NOT EXISTS `timeBank` INT UNSIGNED NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `increment` INT UNSIGNED NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `delay` INT UNSIGNED NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `seed` BIGINT NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `generator` VARCHAR(10) NOT NULL DEFAULT 'random', " +
+		"ADD COLUMN IF NOT EXISTS `tolerance` TINYINT UNSIGNED NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `distance` SMALLINT UNSIGNED NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `opponentDistance` SMALLINT UNSIGNED NOT NULL DEFAULT 0, " +
+		"ADD COLUMN IF NOT EXISTS `pair` VARCHAR(100) NOT NULL DEFAULT '', " +
+		"ADD COLUMN IF NOT EXISTS `firstPlayer` VARCHAR(20) NOT NULL DEFAULT '', " +
+		"ADD COLUMN IF NOT EXISTS `jumps` BOOL NOT NULL DEFAULT FALSE, " +
+		"ADD COLUMN IF NOT EXISTS `teams` VARCHAR(100) NOT NULL DEFAULT '', " +
+		"ADD COLUMN IF NOT EXISTS `variant` VARCHAR(20) NOT NULL DEFAULT 'classic', " +
+		"ADD COLUMN IF NOT EXISTS `visibility` TINYINT UNSIGNED NOT NULL DEFAULT 0")
+	check(err, "can't update table lobbies: %w")
+	//На больших полях кратчайший путь может быть длиннее 255 клеток
+	_, err = s.exec("ALTER TABLE lobbies " +
+		"MODIFY COLUMN `distance` SMALLINT UNSIGNED NOT NULL DEFAULT 0, " +
+		"MODIFY COLUMN `opponentDistance` SMALLINT UNSIGNED NOT NULL DEFAULT 0")
+	check(err, "can't widen distance columns of lobbies: %w")
+	_, _ = s.exec("create or replace view stats as " +
+		"select login, sum(Points) as pts from " +
+		"(select user.login, Count(*)*3 as Points from user inner join game_results on (user.login=game_results.first or user.login=game_results.firstPartner) where result='first' group by ID " +
+		"union all " +
+		"select user.login, Count(*)*3 from user inner join game_results on (user.login=game_results.second or user.login=game_results.secondPartner) where result='second' group by ID " +
+		"union all " +
+		"select user.login, Count(*) from user inner join game_results on (user.login=game_results.first or user.login=game_results.second or " +
+		"user.login=game_results.firstPartner or user.login=game_results.secondPartner) where result='draw' group by ID " +
+		"union all " +
+		"select login, sum(points) from multi_results group by login" +
+		") as temporary group by login;")
+	return errors.Join(errs...)
+}
+
+func (s *sqlStore) AddUser(login string) error {
+	_, err := s.exec("INSERT INTO user VALUES (null ,?) ON DUPLICATE KEY UPDATE `login` = ?", login, login)
+	return err
+}
+
+func (s *sqlStore) HasUser(login string) (bool, error) {
+	rows, err := s.query("SELECT * FROM user WHERE login = ?", login)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), nil
+}
+
+func (s *sqlStore) DeleteUsers() error {
+	_, err := s.exec("DELETE FROM user")
+	return err
+}
+
+//Столбцы таблицы lobbies в том порядке, в котором их читает scanLobby
+const lobbyColumns = "`ID`, `width`, `height`, `gameBarrierCount`, `playerBarrierCount`, `name`, `playersCount`, " +
+	"`timeControl`, `timeBank`, `increment`, `delay`, `seed`, `generator`, `tolerance`, `distance`, " +
+	"`opponentDistance`, `pair`, `firstPlayer`, `jumps`, `teams`, `variant`, " +
+	"`visibility`"
+
+//Обновление всех столбцов лобби, кроме ID и названия, значениями из INSERT. Лобби с отложенными играми не
+//меняются
+var lobbyUpdates = func() string {
+	var res []string
+	for _, column := range strings.Split(lobbyColumns, ", ") {
+		if column != "`ID`" && column != "`name`" {
+			res = append(res, column+" = IF(`ID` IN (SELECT `lobby` FROM adjourned_games), "+column+", VALUES("+column+"))")
+		}
+	}
+	return strings.Join(res, ", ")
+}()
+
+func (s *sqlStore) InsertLobby(info LobbyInfo) (int64, error) {
+	res, err := s.insertLobby(info, "")
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+//ID лобби не меняется, поэтому отложенные в нём игры можно продолжить
+func (s *sqlStore) UpsertLobby(info LobbyInfo) error {
+	_, err := s.insertLobby(info, " ON DUPLICATE KEY UPDATE "+lobbyUpdates)
+	return err
+}
+
+//Добавляет строку лобби, suffix дописывается в конец запроса
+func (s *sqlStore) insertLobby(info LobbyInfo, suffix string) (sql.Result, error) {
+	var teams []byte
+	if info.teamGame() {
+		teams, _ = json.Marshal(info.Teams)
+	}
+	return s.exec("INSERT INTO lobbies ("+lobbyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"+suffix, nil,
+		info.Width, info.Height, info.GameBarrierCount, info.PlayerBarrierCount, info.Name, info.PlayersCount,
+		info.TimeControl, info.TimeBank, info.Increment, info.Delay, info.Seed, info.Generator, info.Tolerance,
+		info.Distance, info.OpponentDistance, info.Pair, info.FirstPlayer, info.Jumps, string(teams), info.Variant, info.Visibility)
+}
+
+//Читает лобби из текущей строки результата запроса, выбирающего столбцы lobbyColumns
+func scanLobby(rows *sql.Rows) (LobbyInfo, error) {
+	var lobbyInfo LobbyInfo
+	var id uint
+	var teams string
+	err := rows.Scan(&id, &lobbyInfo.Width, &lobbyInfo.Height, &lobbyInfo.GameBarrierCount, &lobbyInfo.PlayerBarrierCount,
+		&lobbyInfo.Name, &lobbyInfo.PlayersCount, &lobbyInfo.TimeControl, &lobbyInfo.TimeBank, &lobbyInfo.Increment, &lobbyInfo.Delay, &lobbyInfo.Seed,
+		&lobbyInfo.Generator, &lobbyInfo.Tolerance, &lobbyInfo.Distance, &lobbyInfo.OpponentDistance, &lobbyInfo.Pair,
+		&lobbyInfo.FirstPlayer, &lobbyInfo.Jumps, &teams, &lobbyInfo.Variant, &lobbyInfo.Visibility)
+	if teams != "" {
+		_ = json.Unmarshal([]byte(teams), &lobbyInfo.Teams)
+	}
+	var ID = strconv.Itoa(int(id))
+	lobbyInfo.ID = &ID
+	return lobbyInfo, err
+}
+
+//Читает первое лобби из результата запроса query
+func (s *sqlStore) firstLobby(query string, args ...any) (LobbyInfo, bool, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return LobbyInfo{}, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return LobbyInfo{}, false, nil
+	}
+	info, err := scanLobby(rows)
+	return info, err == nil, err
+}
+
+func (s *sqlStore) Lobby(id int) (LobbyInfo, bool, error) {
+	return s.firstLobby("SELECT "+lobbyColumns+" FROM lobbies WHERE ID = ?", id)
+}
+
+func (s *sqlStore) PairLobby(name, opponent string) (LobbyInfo, bool, error) {
+	return s.firstLobby("SELECT "+lobbyColumns+" FROM lobbies WHERE `name` LIKE concat('%', ?, '%') AND `name` LIKE concat('%', ?, '%') "+
+		"AND `ID` NOT IN (SELECT `lobby` FROM adjourned_games)", name, opponent)
+}
+
+//Лобби, которые не удалось прочитать, пропускаются с записью в журнал
+func (s *sqlStore) Lobbies() ([]LobbyInfo, error) {
+	rows, err := s.query("SELECT " + lobbyColumns + " from lobbies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var infos = make([]LobbyInfo, 0)
+	for rows.Next() {
+		lobbyInfo, err2 := scanLobby(rows)
+		if err2 != nil {
+			s.logger.Error("can't read lobby", "err", err2)
+		}
+		infos = append(infos, lobbyInfo)
+	}
+	return infos, nil
+}
+
+func (s *sqlStore) DeleteLobby(id int) error {
+	_, err := s.exec("DELETE FROM lobbies WHERE ID = ?", id)
+	return err
+}
+
+func (s *sqlStore) DeleteLobbies() error {
+	_, err := s.exec("DELETE FROM lobbies")
+	return err
+}
+
+func (s *sqlStore) SaveResult(res GameResult) error {
+	_, err := s.exec("INSERT INTO game_results (`first`, `second`, `result`, `reason`, `pair`, `firstPartner`, `secondPartner`) VALUES (? ,?, ?, ?, ?, ?, ?)",
+		res.First, res.Second, res.Result, res.Reason, res.Pair, res.FirstPartner, res.SecondPartner)
+	return err
+}
+
+func (s *sqlStore) SavePlace(game, login string, place, points uint8, reason string) error {
+	_, err := s.exec("INSERT INTO multi_results (`game`, `login`, `place`, `points`, `reason`) VALUES (?, ?, ?, ?, ?)",
+		game, login, place, points, reason)
+	return err
+}
+
+func (s *sqlStore) SaveMoveTime(game, login string, turn int, ms uint32, timeout bool) error {
+	_, err := s.exec("INSERT INTO move_times (`game`, `login`, `turn`, `ms`, `timeout`) VALUES (?, ?, ?, ?, ?)",
+		game, login, turn, ms, timeout)
+	return err
+}
+
+func (s *sqlStore) DeleteResults() error {
+	_, err1 := s.exec("DELETE FROM game_results")
+	_, err2 := s.exec("DELETE FROM multi_results")
+	_, err3 := s.exec("DELETE FROM move_times")
+	return errors.Join(err1, err2, err3)
+}
+
+func (s *sqlStore) Stats() ([]Stats, error) {
+	var stats = make([]Stats, 0, MaxPlayers)
+	rows, err := s.query("SELECT * FROM stats ORDER BY pts DESC")
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var login string
+		var pts uint16
+		_ = rows.Scan(&login, &pts)
+		stats = append(stats, Stats{
+			Name:   login,
+			Points: pts,
+		})
+	}
+	return stats, nil
+}
+
+func (s *sqlStore) MoveTimes(login string) ([]MoveTime, error) {
+	rows, err := s.query("SELECT `login`, `ms`, `timeout` FROM move_times WHERE ? = '' OR `login` = ? ORDER BY `login`", login, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []MoveTime
+	for rows.Next() {
+		var val MoveTime
+		_ = rows.Scan(&val.Login, &val.Ms, &val.Timeout)
+		res = append(res, val)
+	}
+	return res, nil
+}
+
+func (s *sqlStore) PairGames() ([]GameResult, error) {
+	rows, err := s.query("SELECT `pair`, `first`, `second`, `result` FROM game_results WHERE `pair` != '' ORDER BY `pair`")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []GameResult
+	for rows.Next() {
+		var val GameResult
+		_ = rows.Scan(&val.Pair, &val.First, &val.Second, &val.Result)
+		res = append(res, val)
+	}
+	return res, nil
+}
+
+func (s *sqlStore) SaveAdjourned(game, lobby, state string) error {
+	_, err := s.exec("INSERT INTO adjourned_games (`game`, `lobby`, `state`) VALUES (?, ?, ?)", game, lobby, state)
+	return err
+}
+
+func (s *sqlStore) LastAdjourned(lobby int) (string, string, bool, error) {
+	rows, err := s.query("SELECT `game`, `state` FROM adjourned_games WHERE `lobby` = ? ORDER BY `adjourned` DESC LIMIT 1", lobby)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", "", false, nil
+	}
+	var game, state string
+	err = rows.Scan(&game, &state)
+	return game, state, err == nil, err
+}
+
+func (s *sqlStore) DeleteAdjourned(game string) error {
+	_, err := s.exec("DELETE FROM adjourned_games WHERE `game` = ?", game)
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Время, которое игрок потратил на один ход, измеренное сервером
+type moveTiming struct {
+	player  int           //Индекс игрока в порядке ходов
+	turn    int           //Номер хода в игре, начиная с 0
+	think   time.Duration //Время от начала хода до получения ответа
+	timeout bool          //Не уложился ли игрок во время
+}
+
+//Время хода в миллисекундах, для отправки клиентам и записи в БД
+func (m moveTiming) millis() uint32 {
+	return uint32(m.think / time.Millisecond)
+}
+
+//Времена ходов игрока player в миллисекундах в порядке ходов
+func playerTimes(timings []moveTiming, player int) []uint32 {
+	var res = make([]uint32, 0, len(timings))
+	for _, val := range timings {
+		if val.player == player {
+			res = append(res, val.millis())
+		}
+	}
+	return res
+}
+
+//Считает статистику времени обдумывания по временам ходов times в миллисекундах и количеству таймаутов
+func thinkStats(name string, times []uint32, timeouts uint32) PlayerStats {
+	var res = PlayerStats{Name: name, Moves: uint32(len(times)), Timeouts: timeouts}
+	if len(times) == 0 {
+		return res
+	}
+	var sorted = append([]uint32{}, times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum uint64
+	for _, val := range sorted {
+		sum += uint64(val)
+	}
+	res.Mean = uint32(sum / uint64(len(sorted)))
+	res.P95 = sorted[(len(sorted)*95+99)/100-1]
+	res.Max = sorted[len(sorted)-1]
+	return res
+}
+
+//HTML со статистикой времени ходов каждого игрока для лога игры
+func timingsLog(names []string, timings []moveTiming) string {
+	var str strings.Builder
+	for i, name := range names {
+		var timeouts uint32
+		for _, val := range timings {
+			if val.player == i && val.timeout {
+				timeouts += 1
+			}
+		}
+		var stats = thinkStats(name, playerTimes(timings, i), timeouts)
+		str.WriteString(fmt.Sprintf("<br>%s: ходов %d, в среднем %d мс, p95 %d мс, максимум %d мс, таймаутов %d",
+			name, stats.Moves, stats.Mean, stats.P95, stats.Max, stats.Timeouts))
+	}
+	return str.String()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"errors"
+	"goServer/board"
+)
+
+//Радиус видимости в тумане войны, если в лобби он не указан
+const DefaultVisibility = 2
+
+//Правила лобби. Если вариант не указан, то используются обычные правила, флаг jumps лобби разрешает прыжки в
+//любом варианте
+func (info LobbyInfo) rules() board.Variant {
+	return board.Rules(info.Variant, info.Jumps)
+}
+
+//Радиус видимости в лобби, 0 - если тумана войны нет
+func (info LobbyInfo) visibility() uint8 {
+	if !info.rules().Fog {
+		return 0
+	}
+	if info.Visibility == 0 {
+		return DefaultVisibility
+	}
+	return info.Visibility
+}
+
+//Проверяет, что вариант правил известен и подходит для лобби
+func checkVariant(info LobbyInfo) error {
+	if info.Variant == "" {
+		return nil
+	}
+	if _, ok := board.Variants[info.Variant]; !ok {
+		return errors.New("unknown rules variant " + info.Variant)
+	}
+	if info.Variant == board.TorusVariant && info.PlayersCount > 2 {
+		return errors.New("torus variant is only for 2 players")
+	}
+	return nil
+}
@@ -50,21 +50,32 @@ func (t *Thinker) PlayGame() {
 	var startGameInfo utils.StartGameInfo
 	res = strings.TrimPrefix(res, "SOCKET STARTGAME")
 	err = json.Unmarshal([]byte(res), &startGameInfo)
+	var positions = startGameInfo.Positions
+	if len(positions) == 0 {
+		positions = [][2]int{startGameInfo.Position, startGameInfo.OpponentPosition}
+	}
+	var goals = startGameInfo.Goals
+	if len(goals) == 0 {
+		//Старый сервер не присылает цели, первый игрок идёт сверху вниз
+		if startGameInfo.Position[0] == 0 {
+			goals = []string{"bottom", "top"}
+		} else {
+			goals = []string{"top", "bottom"}
+		}
+	}
 	game := utils.Game{
-		Goal: func() uint8 {
-			if startGameInfo.Position[0] == 0 {
-				return startGameInfo.Height - 1
-			} else {
-				return 0
-			}
-		}(),
-		GameBarrierCount:   joinLobbyResponse.Data.GameBarrierCount,
-		PlayerBarrierCount: joinLobbyResponse.Data.PlayerBarrierCount,
-		Width:              startGameInfo.Width,
-		Height:             startGameInfo.Height,
-		Position:           startGameInfo.Position,
-		OpponentPosition:   startGameInfo.OpponentPosition,
-		Barriers:           startGameInfo.Barriers,
+		Goal:         goals[0],
+		BarriersLeft: joinLobbyResponse.Data.PlayerBarrierCount,
+		Segments:     2,
+		Width:        startGameInfo.Width,
+		Height:       startGameInfo.Height,
+		Positions:    positions,
+		Goals:        goals,
+		Barriers:     startGameInfo.Barriers,
+		Jumps:        joinLobbyResponse.Data.Jumps || joinLobbyResponse.Data.Variant == "jumps",
+	}
+	if joinLobbyResponse.Data.Variant == "long_barriers" {
+		game.Segments = 3
 	}
 	if startGameInfo.Move {
 		//println("I'm first!")
@@ -134,11 +145,12 @@ func (t *Thinker) sendCommand(str string) error {
 }
 
 func (t *Thinker) makeMove(game *utils.Game) {
-	//fmt.Printf("Я сейчас в [%d, %d], противник в [%d, %d]\n", game.Position[0], game.Position[1], game.OpponentPosition[0], game.OpponentPosition[1])
+	//fmt.Printf("Я сейчас в %v, остальные в %v\n", game.Positions[0], game.Positions[1:])
 	moves := expandMoves(game)
-	var obstacles [][4][2]uint8
+	var obstacles [][][2]int
 	var move string
-	if game.PlayerBarrierCount > 0 {
+	//Препятствия перебираются, только если ходить некуда: на большом поле их перебор долгий
+	if len(moves) == 0 && game.BarriersLeft > 0 {
 		obstacles = expandObstacles(game)
 	}
 	if len(moves)+len(obstacles) == 0 {
@@ -152,19 +164,19 @@ func (t *Thinker) makeMove(game *utils.Game) {
 			}
 			var obstacle = obstacles[moveNumber-len(moves)]
 			game.Barriers = append(game.Barriers, obstacle)
-			game.PlayerBarrierCount -= 1
+			game.BarriersLeft -= 1
 		} else {
 			//fmt.Println("Перемещаюсь")
-			var position = moves[moveNumber]
-			game.Position = position
+			game.Positions[0] = moves[moveNumber]
 		}
 	}
 	var field = utils.Field{
 		Width:            game.Width,
 		Height:           game.Height,
-		Position:         game.Position,
-		OpponentPosition: game.OpponentPosition,
+		Position:         game.Positions[0],
+		OpponentPosition: game.Positions[1],
 		Barriers:         game.Barriers,
+		Positions:        game.Positions,
 	}
 	data, _ := json.Marshal(field)
 	move = fmt.Sprintf("SOCKET STEP %s\n", string(data))
@@ -175,64 +187,91 @@ func (t *Thinker) makeMove(game *utils.Game) {
 	}
 }
 
-func chooseMove(moves [][2]uint8, obstacles [][4][2]uint8) int {
+func chooseMove(moves [][2]int, obstacles [][][2]int) int {
 	return 0
 }
 
 func (t *Thinker) waitTurn(game *utils.Game) (bool, string) {
 	//fmt.Printf("Жду свой ход\n")
-	step := <-t.commandsBuffer
-	//if err != nil {
-	//	println(err.Error())
-	//}
 	re := regexp.MustCompile("[A-Z ]+[A-Z]|(?:{.+})")
-	split := re.FindAllString(step, 2)
-	if len(split) == 2 && split[0] == "SOCKET STEP" {
-		var field utils.Field
-		_ = json.Unmarshal([]byte(split[1]), &field)
-		game.Width = field.Width
-		game.Height = field.Height
-		game.Position = field.Position
-		game.OpponentPosition = field.OpponentPosition
-		game.Barriers = field.Barriers
-		game.Turn += 1
-		return false, ""
-	} else if len(split) == 2 && split[0] == "SOCKET ENDGAME" {
-		var endGameInfo utils.GameResultInfo
-		_ = json.Unmarshal([]byte(split[1]), &endGameInfo)
-		game.OpponentPosition = endGameInfo.OpponentPosition
-		game.Barriers = endGameInfo.Barriers
-		game.Turn += 1
-		return true, endGameInfo.Result
-	}
-	panic("watafak")
+	for {
+		step := <-t.commandsBuffer
+		split := re.FindAllString(step, 2)
+		if len(split) == 2 && split[0] == "SOCKET STEP" {
+			var field utils.Field
+			_ = json.Unmarshal([]byte(split[1]), &field)
+			game.Width = field.Width
+			game.Height = field.Height
+			game.Positions = field.Positions
+			if len(game.Positions) == 0 {
+				game.Positions = [][2]int{field.Position, field.OpponentPosition}
+			}
+			if len(field.Goals) > 0 {
+				game.Goals = field.Goals
+			}
+			game.Barriers = field.Barriers
+			game.BarriersLeft = field.BarriersLeft
+			game.Turn += 1
+			return false, ""
+		} else if len(split) == 2 && split[0] == "SOCKET ENDGAME" {
+			var endGameInfo utils.GameResultInfo
+			_ = json.Unmarshal([]byte(split[1]), &endGameInfo)
+			game.Barriers = endGameInfo.Barriers
+			game.Turn += 1
+			return true, endGameInfo.Result
+		}
+		//Ошибки и прочие сообщения во время игры пропускаются
+	}
 }
 
-func expandObstacles(game *utils.Game) [][4][2]uint8 {
-	res := make([][4][2]uint8, 0, game.Width*game.Height*8)
-	for i := uint8(0); i < game.Height; i++ {
-		for j := uint8(0); j < game.Width; j++ {
-			for k := uint8(0); k < 8; k++ {
-				obstacle := getBarrier(i, j, k)
-				if !isValidObstacle(obstacle, game.Width, game.Height) {
+//Все препятствия, которые можно поставить так, чтобы у каждого видимого игрока остался путь до цели
+func expandObstacles(game *utils.Game) [][][2]int {
+	res := make([][][2]int, 0)
+	for i := 0; i < game.Height; i++ {
+		for j := 0; j < game.Width; j++ {
+			for k := 0; k < 8; k++ {
+				obstacle := getBarrier(i, j, k, game.Segments)
+				if !isValidObstacle(obstacle, game.Width, game.Height) || crossesBarriers(obstacle, game.Barriers) {
 					continue
 				}
-				if isStepOver(obstacle[0], obstacle[1], game.Barriers) || isStepOver(obstacle[2], obstacle[3], game.Barriers) {
-					continue
+				newSetBarriers := append(game.Barriers[:len(game.Barriers):len(game.Barriers)], obstacle)
+				var blocks = false
+				for p, position := range game.Positions {
+					if position != hidden && p < len(game.Goals) &&
+						!isPathExists(position, newSetBarriers, game.Goals[p], game.Width, game.Height) {
+						blocks = true
+						break
+					}
 				}
-				newSetBarriers := append(res, obstacle)
-				if !isPathExists(game.Position, newSetBarriers, game.Goal, game.Width, game.Height) || !isPathExists(game.OpponentPosition, newSetBarriers, game.Height-1-game.Goal, game.Width, game.Height) {
-					continue
+				if !blocks {
+					res = append(res, obstacle)
 				}
-				res = append(res, obstacle)
 			}
 		}
 	}
-	return res[:]
+	return res
 }
 
-func isPathExists(position [2]uint8, obstacles [][4][2]uint8, goal, width, height uint8) bool {
-	if position[0] == goal {
+//Позиция игрока, которого не видно в тумане войны
+var hidden = [2]int{-1, -1}
+
+//Дошёл ли игрок в клетке cell до края goal
+func reached(cell [2]int, goal string, width, height int) bool {
+	switch goal {
+	case "top":
+		return cell[0] == 0
+	case "bottom":
+		return cell[0] == height-1
+	case "left":
+		return cell[1] == 0
+	case "right":
+		return cell[1] == width-1
+	}
+	return false
+}
+
+func isPathExists(position [2]int, obstacles [][][2]int, goal string, width, height int) bool {
+	if reached(position, goal, width, height) {
 		return true
 	}
 	var positions = new(utils.PositionStack)
@@ -245,12 +284,12 @@ func isPathExists(position [2]uint8, obstacles [][4][2]uint8, goal, width, heigh
 		if !ok {
 			break
 		}
-		var moves = getMoves(current, obstacles, width, height, goal)
+		var moves = getMoves(current, obstacles, width, height)
 		for _, val := range moves {
-			if val[0] == goal {
+			if reached(val, goal, width, height) {
 				return true
 			}
-			if !(val[0] == current[0] && val[1] == current[1]) && !visitedCells[val[0]*width+val[1]] {
+			if !visitedCells[val[0]*width+val[1]] {
 				utils.PosPush(&positions, val)
 				visitedCells[val[0]*width+val[1]] = true
 			}
@@ -259,104 +298,151 @@ func isPathExists(position [2]uint8, obstacles [][4][2]uint8, goal, width, heigh
 	return false
 }
 
-func getMoves(current [2]uint8, obstacles [][4][2]uint8, width, height, goal uint8) [][2]uint8 {
-	res := make([][2]uint8, 0, 4)
-	var moves = func() [4][2]uint8 {
-		if goal != 0 {
-			return [4][2]uint8{
-				{current[0] + 1, current[1]},
-				{current[0], current[1] + 1},
-				{current[0], current[1] - 1},
-				{current[0] - 1, current[1]},
-			}
-		} else {
-			return [4][2]uint8{
-				{current[0] - 1, current[1]},
-				{current[0], current[1] + 1},
-				{current[0], current[1] - 1},
-				{current[0] + 1, current[1]},
-			}
-		}
-	}()
+func getMoves(current [2]int, obstacles [][][2]int, width, height int) [][2]int {
+	res := make([][2]int, 0, 4)
+	var moves = [4][2]int{
+		{current[0] + 1, current[1]},
+		{current[0], current[1] + 1},
+		{current[0], current[1] - 1},
+		{current[0] - 1, current[1]},
+	}
 	for i := 0; i < 4; i++ {
-		if moves[i][0] < height && moves[i][1] < width && !isStepOver(current, moves[i], obstacles) {
+		if inField(moves[i], width, height) && !isStepOver(current, moves[i], obstacles) {
 			res = append(res, moves[i])
 		}
 	}
-	return res[:]
+	return res
 }
 
-func isValidObstacle(obstacle [4][2]uint8, width, height uint8) bool {
-	if obstacle[0][0] < 0 || obstacle[0][0] >= height || obstacle[0][1] < 0 || obstacle[0][1] >= width ||
-		obstacle[1][0] < 0 || obstacle[1][0] >= height || obstacle[1][1] < 0 || obstacle[1][1] >= width ||
-		obstacle[2][0] < 0 || obstacle[2][0] >= height || obstacle[2][1] < 0 || obstacle[2][1] >= width ||
-		obstacle[3][0] < 0 || obstacle[3][0] >= height || obstacle[3][1] < 0 || obstacle[3][1] >= width {
-		return false
+func inField(cell [2]int, width, height int) bool {
+	return cell[0] >= 0 && cell[0] < height && cell[1] >= 0 && cell[1] < width
+}
+
+func isValidObstacle(obstacle [][2]int, width, height int) bool {
+	for _, cell := range obstacle {
+		if !inField(cell, width, height) {
+			return false
+		}
 	}
 	return true
 }
 
-func getBarrier(x, y, dir uint8) [4][2]uint8 {
+//Проверяет, пересекается ли препятствие с уже стоящими: перегородки не могут совпадать
+func crossesBarriers(obstacle [][2]int, barriers [][][2]int) bool {
+	for i := 0; i+1 < len(obstacle); i += 2 {
+		if isStepOver(obstacle[i], obstacle[i+1], barriers) {
+			return true
+		}
+	}
+	return false
+}
+
+//Препятствие из segments перегородок в клетке (x,y) в одном из восьми направлений dir, как на сервере
+func getBarrier(x, y, dir, segments int) [][2]int {
+	var first [4][2]int
 	switch dir {
 	case 0:
-		return [4][2]uint8{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
+		first = [4][2]int{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
 	case 1:
-		return [4][2]uint8{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}}
+		first = [4][2]int{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}}
 	case 2:
-		return [4][2]uint8{{x, y}, {x - 1, y}, {x, y - 1}, {x - 1, y - 1}}
+		first = [4][2]int{{x, y}, {x - 1, y}, {x, y - 1}, {x - 1, y - 1}}
 	case 3:
-		return [4][2]uint8{{x, y}, {x - 1, y}, {x, y + 1}, {x - 1, y + 1}}
+		first = [4][2]int{{x, y}, {x - 1, y}, {x, y + 1}, {x - 1, y + 1}}
 	case 4:
-		return [4][2]uint8{{x, y}, {x, y + 1}, {x + 1, y}, {x + 1, y + 1}}
+		first = [4][2]int{{x, y}, {x, y + 1}, {x + 1, y}, {x + 1, y + 1}}
 	case 5:
-		return [4][2]uint8{{x, y}, {x, y - 1}, {x + 1, y}, {x + 1, y - 1}}
+		first = [4][2]int{{x, y}, {x, y - 1}, {x + 1, y}, {x + 1, y - 1}}
 	case 6:
-		return [4][2]uint8{{x, y}, {x, y + 1}, {x - 1, y}, {x - 1, y + 1}}
+		first = [4][2]int{{x, y}, {x, y + 1}, {x - 1, y}, {x - 1, y + 1}}
 	case 7:
-		return [4][2]uint8{{x, y}, {x, y - 1}, {x - 1, y}, {x - 1, y - 1}}
+		first = [4][2]int{{x, y}, {x, y - 1}, {x - 1, y}, {x - 1, y - 1}}
 	default:
-		return [4][2]uint8{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
+		first = [4][2]int{{x, y}, {x + 1, y}, {x, y - 1}, {x + 1, y - 1}}
+	}
+	var res = append(make([][2]int, 0, 2*segments), first[:]...)
+	for i := 2; i < segments; i++ {
+		var last = len(res)
+		res = append(res,
+			[2]int{2*res[last-2][0] - res[last-4][0], 2*res[last-2][1] - res[last-4][1]},
+			[2]int{2*res[last-1][0] - res[last-3][0], 2*res[last-1][1] - res[last-3][1]})
 	}
+	return res
 }
 
-func expandMoves(game *utils.Game) [][2]uint8 {
-	var moves [4][2]uint8
-	res := make([][2]uint8, 0, 4)
-	moves[0] = [2]uint8{game.Position[0] + 1, game.Position[1]}
-	moves[1] = [2]uint8{game.Position[0], game.Position[1] + 1}
-	moves[2] = [2]uint8{game.Position[0], game.Position[1] - 1}
-	moves[3] = [2]uint8{game.Position[0] - 1, game.Position[1]}
-	for i := 0; i < 4; i++ {
-		if isLegalMove(game.Position, moves[i], game) {
+func expandMoves(game *utils.Game) [][2]int {
+	var position = game.Positions[0]
+	var moves = make([][2]int, 0, 8)
+	res := make([][2]int, 0, 4)
+	moves = append(moves, [2]int{position[0] + 1, position[1]})
+	moves = append(moves, [2]int{position[0], position[1] + 1})
+	moves = append(moves, [2]int{position[0], position[1] - 1})
+	moves = append(moves, [2]int{position[0] - 1, position[1]})
+	//Прыгать бот умеет только в игре двух игроков
+	if game.Jumps && len(game.Positions) == 2 && isNeighbour(position, game.Positions[1]) {
+		//Клетки вокруг противника, в которые можно попасть прыжком
+		var opponent = game.Positions[1]
+		moves = append(moves, [2]int{opponent[0] + 1, opponent[1]})
+		moves = append(moves, [2]int{opponent[0], opponent[1] + 1})
+		moves = append(moves, [2]int{opponent[0], opponent[1] - 1})
+		moves = append(moves, [2]int{opponent[0] - 1, opponent[1]})
+	}
+	for i := 0; i < len(moves); i++ {
+		if isLegalMove(position, moves[i], game) {
 			res = append(res, moves[i])
 		}
 	}
 	return res
 }
 
-func isLegalMove(from, to [2]uint8, game *utils.Game) bool {
-	if to[0] < 0 || to[0] >= game.Height || to[1] < 0 || to[1] >= game.Width {
-		//fmt.Printf("Я не могу сходить из [%d, %d] в [%d, %d] потому что за пределами поля\n", game.Position[0], game.Position[1], to[0], to[1])
+func isLegalMove(from, to [2]int, game *utils.Game) bool {
+	if !inField(to, game.Width, game.Height) {
+		//fmt.Printf("Я не могу сходить из %v в %v потому что за пределами поля\n", from, to)
 		return false
 	}
-	if to[0] == game.OpponentPosition[0] && to[1] == game.OpponentPosition[1] {
-		//fmt.Printf("Я не могу сходить из [%d, %d] в [%d, %d] потому что сяду на лицо противнику\n", game.Position[0], game.Position[1], to[0], to[1])
-		return false
+	for _, val := range game.Positions[1:] {
+		if to == val {
+			//fmt.Printf("Я не могу сходить из %v в %v потому что там другой игрок\n", from, to)
+			return false
+		}
 	}
-	if isStepOver(from, to, game.Barriers) {
-		//fmt.Printf("Я не могу сходить из [%d, %d] в [%d, %d] потому что там препятствие\n", game.Position[0], game.Position[1], to[0], to[1])
+	if isNeighbour(from, to) {
+		if isStepOver(from, to, game.Barriers) {
+			//fmt.Printf("Я не могу сходить из %v в %v потому что там препятствие\n", from, to)
+			return false
+		}
+		return true
+	}
+	return game.Jumps && isLegalJump(from, to, game)
+}
+
+//Прыжок возможен только через стоящего рядом противника. Прямо, если за противником нет препятствия и края поля,
+//иначе - по диагонали
+func isLegalJump(from, to [2]int, game *utils.Game) bool {
+	var opponent = game.Positions[1]
+	if !isNeighbour(from, opponent) || !isNeighbour(opponent, to) || isStepOver(from, opponent, game.Barriers) ||
+		isStepOver(opponent, to, game.Barriers) {
 		return false
 	}
-	return true
+	var behind = [2]int{2*opponent[0] - from[0], 2*opponent[1] - from[1]}
+	if to == behind {
+		return true
+	}
+	var straightBlocked = !inField(behind, game.Width, game.Height) || isStepOver(opponent, behind, game.Barriers)
+	return straightBlocked && to != from
+}
+
+func isNeighbour(a, b [2]int) bool {
+	return a[0] == b[0] && (a[1]-b[1] == 1 || b[1]-a[1] == 1) || a[1] == b[1] && (a[0]-b[0] == 1 || b[0]-a[0] == 1)
 }
 
-func isStepOver(from, to [2]uint8, b [][4][2]uint8) bool {
+//Проверяет, стоит ли между клетками from и to перегородка одного из препятствий b
+func isStepOver(from, to [2]int, b [][][2]int) bool {
 	for _, val := range b {
-		if (from[0] == val[0][0] && from[1] == val[0][1] && to[0] == val[1][0] && to[1] == val[1][1]) ||
-			(from[0] == val[2][0] && from[1] == val[2][1] && to[0] == val[3][0] && to[1] == val[3][1]) ||
-			(from[0] == val[1][0] && from[1] == val[1][1] && to[0] == val[0][0] && to[1] == val[0][1]) ||
-			(from[0] == val[3][0] && from[1] == val[3][1] && to[0] == val[2][0] && to[1] == val[2][1]) {
-			return true
+		for i := 0; i+1 < len(val); i += 2 {
+			if from == val[i] && to == val[i+1] || to == val[i] && from == val[i+1] {
+				return true
+			}
 		}
 	}
 	return false
@@ -11,58 +11,70 @@ type JoinLobbyResponse struct {
 
 type LobbyInfo struct {
 	ID                 string `json:"_id"`
-	Width              uint8  `json:"width"`
-	Height             uint8  `json:"height"`
-	GameBarrierCount   uint8  `json:"gameBarrierCount"`
-	PlayerBarrierCount uint8  `json:"playerBarrierCount"`
+	Width              int    `json:"width"`
+	Height             int    `json:"height"`
+	GameBarrierCount   int    `json:"gameBarrierCount"`
+	PlayerBarrierCount int    `json:"playerBarrierCount"`
 	Name               string `json:"name"`
-	PlayersCount       uint8  `json:"players_count"`
+	PlayersCount       int    `json:"players_count"`
+	Jumps              bool   `json:"jumps"`
+	Variant            string `json:"variant"`
 }
 
+//Препятствие - это список пар клеток, между которыми стоят перегородки: две пары в обычных правилах, три в
+//long_barriers. Позиция игрока, которого не видно в тумане войны, - [-1, -1]
 type StartGameInfo struct {
-	Move             bool          `json:"move"`
-	Width            uint8         `json:"width"`
-	Height           uint8         `json:"height"`
-	Position         [2]uint8      `json:"position"`
-	OpponentPosition [2]uint8      `json:"opponentPosition"`
-	Barriers         [][4][2]uint8 `json:"barriers"`
+	Move             bool       `json:"move"`
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
+	Goals            []string   `json:"goals"`
+	BarriersLeft     int        `json:"barriersLeft"`
 }
 
 type Game struct {
-	Turn               uint8
-	Goal               uint8
-	GameBarrierCount   uint8
-	PlayerBarrierCount uint8
-	Width              uint8
-	Height             uint8
-	Position           [2]uint8
-	OpponentPosition   [2]uint8
-	Barriers           [][4][2]uint8
+	Turn         int
+	Goal         string //Край поля, до которого нужно дойти: top, bottom, left или right
+	BarriersLeft int
+	Segments     int //Из скольких перегородок состоит препятствие
+	Width        int
+	Height       int
+	Positions    [][2]int //Позиции игроков, первая - своя
+	Goals        []string //Цели игроков в том же порядке
+	Barriers     [][][2]int
+	Jumps        bool
 }
 
 type Field struct {
-	Width            uint8         `json:"width"`
-	Height           uint8         `json:"height"`
-	Position         [2]uint8      `json:"position"`
-	OpponentPosition [2]uint8      `json:"opponentPosition"`
-	Barriers         [][4][2]uint8 `json:"barriers"`
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
+	Goals            []string   `json:"goals"`
+	BarriersLeft     int        `json:"barriersLeft"`
 }
 
 type GameResultInfo struct {
-	Result           string        `json:"result"`
-	Width            uint8         `json:"width"`
-	Height           uint8         `json:"height"`
-	Position         [2]uint8      `json:"position"`
-	OpponentPosition [2]uint8      `json:"opponentPosition"`
-	Barriers         [][4][2]uint8 `json:"barriers"`
+	Result           string     `json:"result"`
+	Width            int        `json:"width"`
+	Height           int        `json:"height"`
+	Position         [2]int     `json:"position"`
+	OpponentPosition [2]int     `json:"opponentPosition"`
+	Barriers         [][][2]int `json:"barriers"`
+	Positions        [][2]int   `json:"positions"`
 }
 
 type PositionStack struct {
-	f    [2]uint8
+	f    [2]int
 	next *PositionStack
 }
 
-func PosPush(stack **PositionStack, position [2]uint8) {
+func PosPush(stack **PositionStack, position [2]int) {
 	var newRoot = new(PositionStack)
 	*newRoot = PositionStack{
 		f:    position,
@@ -71,9 +83,9 @@ func PosPush(stack **PositionStack, position [2]uint8) {
 	*stack = newRoot
 }
 
-func PosPop(stack **PositionStack) ([2]uint8, bool) {
+func PosPop(stack **PositionStack) ([2]int, bool) {
 	if *stack == nil {
-		return [2]uint8{}, false
+		return [2]int{}, false
 	}
 	var temp = *stack
 	*stack = (*stack).next